@@ -1,35 +1,100 @@
 package cmd
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
 	"github.com/edgewize-io/image-packaging-tool/pkg/imageref"
+	"github.com/edgewize-io/image-packaging-tool/pkg/layercache"
 	"github.com/edgewize-io/image-packaging-tool/pkg/lock"
+	"github.com/edgewize-io/image-packaging-tool/pkg/modelimage"
+	"github.com/edgewize-io/image-packaging-tool/pkg/progress"
+	"github.com/edgewize-io/image-packaging-tool/pkg/provenance"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
 	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/version"
+	"github.com/edgewize-io/image-packaging-tool/pkg/sbom"
 	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/edgewize-io/image-packaging-tool/pkg/signature"
+	"github.com/edgewize-io/image-packaging-tool/pkg/upload"
 	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
-	"github.com/regclient/regclient/mod"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types"
+	"github.com/regclient/regclient/types/descriptor"
+	v1 "github.com/regclient/regclient/types/oci/v1"
 	"github.com/regclient/regclient/types/platform"
 	"github.com/regclient/regclient/types/ref"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
 	"io"
+	"io/fs"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 type BuildOptions struct {
 	rootOpts             *RootOptions
 	baseImage            string
 	targetImage          string
+	specFile             string
+	dryRun               bool
+	labels               map[string]string
+	env                  []string
+	labelFlags           []string
+	envFlags             []string
+	ports                []string
+	workingDir           string
+	user                 string
+	stopSignal           string
+	cmd                  []string
+	entrypointMode       string
+	owner                string
+	fileMode             string
+	dirMode              string
+	readOnly             bool
+	dereference          bool
+	layerOpts            []archive.TarOpts
+	ignore               []string
+	matrix               []string
+	matrixEntries        []matrixEntry
+	matrixDeviceType     string
+	tags                 []string
 	platforms            string
 	skipScript           bool
 	outputServerFilePath string
 	deviceType           string
+	signKey              string
+	signer               crypto.Signer
+	provenance           bool
+	sbom                 bool
+	locked               bool
+	cacheDir             string
+	uploadChunkSize      string
+	chunkSize            int64
+	uploadRetries        int
+	uploadBackoff        time.Duration
+	limitRate            string
+	compressionThreads   int
+	maxLayerSize         string
+	removePaths          []string
+	squash               bool
+	progressMode         string
+	progress             *progress.Reporter
+	pushSBOM             bool
 }
 
 type ModelTemplateParam struct {
@@ -43,17 +108,81 @@ func NewCmdBuild(rootOptions *RootOptions) *cobra.Command {
 	}
 
 	command := &cobra.Command{
-		Use:   "build",
+		Use:   "build [target image]",
 		Short: "build model image",
-		Long:  "init new model for current image",
+		Long: `Build a model image from the current workspace and push it.
+
+The target image and --tag values may be Go templates using .Name and .Version
+from server.yaml, .DeviceType, .Platform, .GitSHA and .Date, plus the functions
+lower, upper, replace, major and majorMinor. A --tag without registry and
+repository tags the repository of the target image.
+
+Build inputs may be declared in packctl.yaml in the workspace, or in the build
+section of .modelmesh/server.yaml, with the fields baseImage, target, platforms,
+deviceType, tags, labels, env, ports, workdir, user, stopSignal, cmd, entrypointMode,
+owner, fileMode, dirMode, readOnly, dereference, maxLayerSize, removePaths, squash
+and ignore.
+Flags override the values of the file, --label and --env per name.
+
+--entrypoint-mode decides how start.sh relates to the entrypoint of the base image:
+replace runs start.sh instead of it, chain runs the base entrypoint with start.sh as
+its arguments so it must exec them, keep leaves the entrypoint and cmd of the base
+image unchanged. replace and chain require /bin/bash in the base image. On a base
+image built by packctl the entrypoint and cmd of the image it was built on are used,
+so the start.sh of the earlier build does not run.
+
+--owner, --file-mode, --dir-mode and --read-only set the ownership and permissions of
+the files added by the build instead of copying them from the host, --owner is also
+the default --user so the image runs as a non-root user owning the model files.
+
+--max-layer-size splits the workspace into several layers for registries that reject
+large blobs. Files larger than a layer are stored in parts that start.sh joins on
+the first start of the container, this needs a workspace directory the image user
+can write. It cannot be combined with --read-only, --entrypoint-mode keep, a
+--dir-mode without owner write permission or a --user other than --owner, and the
+container cannot run with a read-only root filesystem.
+
+--remove-path hides a file or directory of the base image, such as sample servables,
+with an OCI whiteout in the first added layer. Paths are not resolved, remove the
+target of a symlink rather than a path below it.
+
+The ignore patterns of the spec skip workspace files like a .gitignore: *.tmp skips
+matching files and directories at any depth, a pattern with a slash such as
+models/*.bak matches the path in the workspace, ** matches any number of directories
+and a trailing slash only matches directories.
+
+--squash flattens the layers added by packctl, including those of a packctl base
+image, into a single layer on top of the base image they were built on. Files
+hidden or replaced by upper layers are dropped, the whiteouts are kept so paths of
+the base image stay hidden. It cannot be combined with --max-layer-size.`,
+		Example: `
+# publish v1.2.0, v1.2, latest and the git commit of the workspace
+packctl build registry.example.org/models/resnet:{{.Version}} --baseImage registry.example.org/base:ascend \
+  --tag '{{.Version | majorMinor}}' --tag latest --tag 'sha-{{.GitSHA}}'
+
+# set the image config instead of patching the deployment
+packctl build registry.example.org/models/resnet:v1 --baseImage registry.example.org/base:ascend \
+  --env MODEL_THREADS=4 --port 8080 --workdir /opt/model --user 1000:1000 --stop-signal SIGINT`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 0 {
 				buildOptions.targetImage = args[0]
-			} else {
+			}
+
+			err := buildOptions.applySpec(cmd.Flags())
+			if err != nil {
+				return err
+			}
+
+			if buildOptions.targetImage == "" && len(buildOptions.tags) > 0 {
+				buildOptions.targetImage, buildOptions.tags = buildOptions.tags[0], buildOptions.tags[1:]
+			}
+
+			if buildOptions.targetImage == "" {
 				return fmt.Errorf("target image name cannot be empty")
 			}
 
-			err := buildOptions.validate()
+			err = buildOptions.validate()
 			if err != nil {
 				return err
 			}
@@ -62,163 +191,1505 @@ func NewCmdBuild(rootOptions *RootOptions) *cobra.Command {
 		},
 	}
 
-	flags := command.Flags()
-	flags.StringVar(&buildOptions.baseImage, "baseImage", "", "base infer model image")
-	flags.StringVar(&buildOptions.platforms, "platforms", "linux/amd64", "build image platforms, default: linux/amd64")
-	flags.BoolVar(&buildOptions.skipScript, "skipScript", false, "skip generating serving_server.py, default: false")
-	flags.StringVar(&buildOptions.outputServerFilePath, "file", "server.yaml", "output server.yaml path")
-	flags.StringVar(&buildOptions.deviceType, "deviceType", "Ascend", "device type, support: [\"CPU\", \"GPU\", \"Ascend\"], default \"Ascend\"")
+	flags := command.Flags()
+	flags.StringVar(&buildOptions.baseImage, "baseImage", "", "base infer model image")
+	flags.StringArrayVar(&buildOptions.tags, "tag", []string{}, "additional tag or image to publish the build as, may be a template, repeatable")
+	flags.BoolVar(&buildOptions.dryRun, "dry-run", false, "resolve the base image and print the layers, generated files, image config and server.yaml of the build without pushing or changing the workspace")
+	flags.StringVar(&buildOptions.specFile, "spec", "", "build spec file, default: packctl.yaml in the workspace, or the build section of .modelmesh/server.yaml")
+	flags.StringArrayVar(&buildOptions.matrix, "matrix", []string{}, "build the workspace for a device type, e.g. deviceType=GPU,baseImage=registry.example.org/base:gpu[,platform=linux/amd64], repeatable, replaces --deviceType and --baseImage")
+	flags.StringArrayVar(&buildOptions.envFlags, "env", []string{}, "environment variable KEY=value of the image, repeatable")
+	flags.StringArrayVar(&buildOptions.labelFlags, "label", []string{}, "label name=value of the image, repeatable")
+	flags.StringArrayVar(&buildOptions.ports, "port", []string{}, "port[/protocol] the image exposes, e.g. 8080 or 53/udp, repeatable")
+	flags.StringVar(&buildOptions.workingDir, "workdir", "", "working directory of the image, default: working directory of the base image")
+	flags.StringVar(&buildOptions.user, "user", "", "user[:group] or uid[:gid] the image runs as, default: user of the base image")
+	flags.StringVar(&buildOptions.stopSignal, "stop-signal", "", "signal that stops the container, e.g. SIGINT, default: stop signal of the base image")
+	flags.StringArrayVar(&buildOptions.cmd, "cmd", nil, "cmd argument of the image, repeatable, default: cmd of the base image")
+	flags.StringVar(&buildOptions.owner, "owner", "", "numeric uid[:gid] owning the files added by the build, also the default --user, default: owner on the host")
+	flags.StringVar(&buildOptions.fileMode, "file-mode", "", "octal permissions of the files added by the build, e.g. 0644, executables stay executable, default: permissions on the host")
+	flags.StringVar(&buildOptions.dirMode, "dir-mode", "", "octal permissions of the directories added by the build, e.g. 0755, default: permissions on the host")
+	flags.BoolVar(&buildOptions.readOnly, "read-only", false, "remove the write permissions of the files added by the build")
+	flags.BoolVar(&buildOptions.dereference, "dereference", false, "add the files symlinks in the workspace point to instead of the symlinks, files linked several times are stored once")
+	flags.StringVar(&buildOptions.entrypointMode, "entrypoint-mode", constants.EntrypointReplace, "how start.sh treats the base image entrypoint, support: [\"replace\", \"chain\", \"keep\"]")
+	flags.StringVar(&buildOptions.platforms, "platforms", "linux/amd64", "build image platforms, default: linux/amd64")
+	flags.BoolVar(&buildOptions.skipScript, "skipScript", false, "skip generating serving_server.py, default: false")
+	flags.StringVar(&buildOptions.outputServerFilePath, "file", "server.yaml", "output server.yaml path")
+	flags.StringVar(&buildOptions.deviceType, "deviceType", "Ascend", "device type, support: [\"CPU\", \"GPU\", \"Ascend\"], default \"Ascend\"")
+	flags.StringVar(&buildOptions.signKey, "sign-key", "", "private key file to sign the pushed image with")
+	flags.BoolVar(&buildOptions.provenance, "provenance", true, "attach a SLSA provenance attestation to the pushed image, signed when --sign-key is set")
+	flags.BoolVar(&buildOptions.locked, "locked", false, "refuse to build when the base image no longer resolves to the digest pinned in .modelmesh/packctl.lock")
+	flags.StringVar(&buildOptions.cacheDir, "cache-dir", "", "directory layers are spooled to before upload, default: ~/.packctl/cache")
+	flags.StringVar(&buildOptions.uploadChunkSize, "upload-chunk-size", "16MiB", "size of the chunks layers are uploaded in, an interrupted upload resumes from the last chunk")
+	flags.IntVar(&buildOptions.uploadRetries, "upload-retries", upload.DefaultRetries, "how often an interrupted layer upload is resumed before giving up")
+	flags.DurationVar(&buildOptions.uploadBackoff, "upload-backoff", upload.DefaultBackoff, "wait before resuming an interrupted upload, doubled on every retry up to 1m")
+	flags.StringVar(&buildOptions.limitRate, "limit-rate", "", "limit layer upload and download throughput per second, e.g. 2MiB, default: limitRate of the registry in the config file")
+	flags.StringArrayVar(&buildOptions.removePaths, "remove-path", []string{}, "absolute path of the base image to hide with a whiteout, repeatable, --dry-run lists what it hides")
+	flags.BoolVar(&buildOptions.squash, "squash", false, "flatten the layers added by packctl, also those of a packctl base image, into a single layer")
+	flags.StringVar(&buildOptions.maxLayerSize, "max-layer-size", "0", "split the workspace into layers of at most this size, e.g. 2GiB, larger files are split and joined again when the container starts, 0 packs a single layer")
+	flags.IntVar(&buildOptions.compressionThreads, "compression-threads", 0, "goroutines compressing a layer in parallel, the layer digest does not depend on it, default: one per CPU")
+	flags.StringVar(&buildOptions.progressMode, "progress", progress.ModeAuto, "progress output on stderr, support: [\"auto\", \"tty\", \"plain\", \"none\"]")
+	flags.BoolVar(&buildOptions.sbom, "sbom", true, "write a CycloneDX SBOM of the files added by the build next to server.yaml")
+	flags.BoolVar(&buildOptions.pushSBOM, "push-sbom", false, "push the SBOM to the registry as a referrer of the pushed image")
+
+	return command
+}
+
+// applySpec fills the options not given as flags from the build spec
+func (bo *BuildOptions) applySpec(flags *pflag.FlagSet) (err error) {
+	currWorkDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	specFilePath := bo.specFile
+	if specFilePath == "" {
+		specFilePath = filepath.Join(currWorkDir, constants.BuildSpecFile)
+		if _, statErr := os.Stat(specFilePath); statErr != nil {
+			specFilePath = ""
+		}
+	}
+
+	var spec *server.BuildSpec
+	if specFilePath != "" {
+		spec, err = server.ReadBuildSpec(specFilePath)
+	} else {
+		spec, err = server.ReadServerBuildSpec(filepath.Join(currWorkDir, constants.MetaDirName, constants.ServerConfigFile))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if err != nil || spec == nil {
+		return
+	}
+
+	setString := func(flagName string, value *string, specValue string) {
+		if specValue != "" && !flags.Changed(flagName) {
+			*value = specValue
+		}
+	}
+
+	setString("baseImage", &bo.baseImage, spec.BaseImage)
+	setString("platforms", &bo.platforms, spec.Platforms)
+	setString("deviceType", &bo.deviceType, spec.DeviceType)
+	setString("workdir", &bo.workingDir, spec.WorkingDir)
+	setString("user", &bo.user, spec.User)
+	setString("stop-signal", &bo.stopSignal, spec.StopSignal)
+	setString("entrypoint-mode", &bo.entrypointMode, spec.EntrypointMode)
+	setString("owner", &bo.owner, spec.Owner)
+	setString("file-mode", &bo.fileMode, spec.FileMode)
+	setString("dir-mode", &bo.dirMode, spec.DirMode)
+	setString("max-layer-size", &bo.maxLayerSize, spec.MaxLayerSize)
+	if spec.ReadOnly && !flags.Changed("read-only") {
+		bo.readOnly = true
+	}
+	if spec.Dereference && !flags.Changed("dereference") {
+		bo.dereference = true
+	}
+	if spec.Squash && !flags.Changed("squash") {
+		bo.squash = true
+	}
+	if bo.targetImage == "" {
+		bo.targetImage = spec.Target
+	}
+	if len(spec.Tags) > 0 && !flags.Changed("tag") {
+		bo.tags = spec.Tags
+	}
+	if len(spec.Ports) > 0 && !flags.Changed("port") {
+		bo.ports = spec.Ports
+	}
+	if len(spec.Cmd) > 0 && !flags.Changed("cmd") {
+		bo.cmd = spec.Cmd
+	}
+	if len(spec.RemovePaths) > 0 && !flags.Changed("remove-path") {
+		bo.removePaths = spec.RemovePaths
+	}
+
+	bo.labels = spec.Labels
+	bo.env = spec.EnvList()
+	bo.ignore = spec.Ignore
+	return
+}
+
+func (bo *BuildOptions) validate() (err error) {
+	if len(bo.matrix) > 0 {
+		bo.matrixEntries, err = parseMatrix(bo.rootOpts.messages(), bo.matrix, bo.platforms)
+		if err != nil {
+			return
+		}
+	} else {
+		err = validateDeviceType(bo.rootOpts.messages(), bo.deviceType)
+		if err != nil {
+			return
+		}
+
+		if bo.baseImage == "" {
+			return fmt.Errorf("base image cannnot be empty!")
+		}
+	}
+
+	err = bo.validateLayerOpts()
+	if err != nil {
+		return
+	}
+
+	err = bo.validateImageConfig()
+	if err != nil {
+		return
+	}
+
+	chunkSize, err := utils.ParseSize(bo.uploadChunkSize)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("invalid --upload-chunk-size %s", bo.uploadChunkSize)
+	}
+	bo.chunkSize = chunkSize
+
+	maxSize, err := bo.layerLimit()
+	if err != nil {
+		return fmt.Errorf("invalid --max-layer-size: %w", err)
+	}
+	if bo.squash && maxSize > 0 {
+		return fmt.Errorf("--squash cannot be combined with --max-layer-size")
+	}
+	if maxSize > 0 {
+		err = bo.validateJoinParts()
+		if err != nil {
+			return
+		}
+	}
+
+	err = bo.validateRemovePaths()
+	if err != nil {
+		return
+	}
+
+	if bo.compressionThreads < 0 {
+		return fmt.Errorf("invalid --compression-threads %d", bo.compressionThreads)
+	}
+
+	bo.progress, err = progress.New(os.Stderr, bo.progressMode)
+	if err != nil {
+		return err
+	}
+
+	if bo.signKey != "" {
+		signer, err := signature.LoadPrivateKey(bo.signKey)
+		if err != nil {
+			return err
+		}
+		bo.signer = signer
+	}
+
+	if bo.skipScript {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("please provide serving_server.py yourself\n"))
+	}
+
+	return nil
+}
+
+// validateLayerOpts parses the owner, permissions and symlink handling of the added files, the owner becomes the
+// user of the image unless one is given
+func (bo *BuildOptions) validateLayerOpts() (err error) {
+	bo.layerOpts = nil
+	if bo.owner != "" {
+		uid, gid, err := server.ParseOwner(bo.owner)
+		if err != nil {
+			return err
+		}
+
+		bo.layerOpts = append(bo.layerOpts, archive.TarOwner(uid, gid))
+		if bo.user == "" {
+			bo.user = fmt.Sprintf("%d:%d", uid, gid)
+		}
+	}
+
+	var fileMode, dirMode fs.FileMode
+	if bo.fileMode != "" {
+		fileMode, err = server.ParseFileMode(bo.fileMode)
+		if err != nil {
+			return
+		}
+	}
+	if bo.dirMode != "" {
+		dirMode, err = server.ParseFileMode(bo.dirMode)
+		if err != nil {
+			return
+		}
+	}
+	bo.layerOpts = append(bo.layerOpts, archive.TarMode(fileMode, dirMode))
+
+	if bo.readOnly {
+		bo.layerOpts = append(bo.layerOpts, archive.TarReadOnly)
+	}
+	if bo.dereference {
+		bo.layerOpts = append(bo.layerOpts, archive.TarDereference)
+	}
+
+	return
+}
+
+// validateJoinParts rejects the options that keep start.sh from joining the files split by --max-layer-size, it
+// runs as the image user and writes the joined files to the workspace
+func (bo *BuildOptions) validateJoinParts() error {
+	if bo.entrypointMode == constants.EntrypointKeep {
+		return fmt.Errorf("--max-layer-size cannot be combined with --entrypoint-mode %s, start.sh joins the split files", constants.EntrypointKeep)
+	}
+	if bo.readOnly {
+		return fmt.Errorf("--max-layer-size cannot be combined with --read-only, start.sh writes the joined files to the workspace")
+	}
+	if bo.dirMode != "" {
+		dirMode, err := server.ParseFileMode(bo.dirMode)
+		if err != nil {
+			return err
+		}
+		if dirMode&0300 != 0300 {
+			return fmt.Errorf("--max-layer-size needs a --dir-mode the owner can write, received %s", bo.dirMode)
+		}
+	}
+	if bo.owner != "" {
+		uid, _, err := server.ParseOwner(bo.owner)
+		if err != nil {
+			return err
+		}
+		user, _, _ := strings.Cut(bo.user, ":")
+		if user != strconv.Itoa(uid) && user != "0" && user != "root" {
+			return fmt.Errorf("--max-layer-size needs --user %s to be the --owner %s, start.sh writes the joined files to the workspace", bo.user, bo.owner)
+		}
+	}
+
+	return nil
+}
+
+// validateRemovePaths cleans the base image paths to remove, they may not overlap the workspace added by the build
+func (bo *BuildOptions) validateRemovePaths() (err error) {
+	currWorkDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	workspace := filepath.ToSlash(currWorkDir)
+	for i, removePath := range bo.removePaths {
+		err = server.ValidateRemovePath(removePath)
+		if err != nil {
+			return
+		}
+
+		removePath = path.Clean(removePath)
+		if removePath == workspace || strings.HasPrefix(workspace, removePath+"/") || strings.HasPrefix(removePath, workspace+"/") {
+			return fmt.Errorf("--remove-path %s overlaps the workspace %s", removePath, workspace)
+		}
+		bo.removePaths[i] = removePath
+	}
+
+	return
+}
+
+// removedBy returns the --remove-path that hides name, empty when name stays
+func (bo *BuildOptions) removedBy(name string) string {
+	for _, removePath := range bo.removePaths {
+		if name == removePath || strings.HasPrefix(name, removePath+"/") {
+			return removePath
+		}
+	}
+	return ""
+}
+
+// validateImageConfig merges --env and --label over the values of the spec and checks the image config settings
+func (bo *BuildOptions) validateImageConfig() (err error) {
+	env := map[string]string{}
+	for _, entry := range bo.env {
+		name, value, _ := strings.Cut(entry, "=")
+		env[name] = value
+	}
+	for _, entry := range bo.envFlags {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" || strings.Contains(name, " ") {
+			return fmt.Errorf("invalid --env %s, use KEY=value", entry)
+		}
+		env[name] = value
+	}
+	bo.env = []string{}
+	for name, value := range env {
+		bo.env = append(bo.env, name+"="+value)
+	}
+	sort.Strings(bo.env)
+
+	labels := map[string]string{}
+	for name, value := range bo.labels {
+		labels[name] = value
+	}
+	for _, entry := range bo.labelFlags {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			return fmt.Errorf("invalid --label %s, use name=value", entry)
+		}
+		labels[name] = value
+	}
+	bo.labels = labels
+
+	for i, port := range bo.ports {
+		bo.ports[i], err = server.NormalizePort(port)
+		if err != nil {
+			return
+		}
+	}
+
+	if bo.workingDir != "" && !path.IsAbs(bo.workingDir) {
+		return fmt.Errorf("working directory %s must be absolute", bo.workingDir)
+	}
+
+	if bo.stopSignal != "" {
+		err = server.ValidateStopSignal(bo.stopSignal)
+		if err != nil {
+			return
+		}
+	}
+
+	err = server.ValidateEntrypointMode(bo.entrypointMode)
+	if err != nil {
+		return
+	}
+
+	if bo.entrypointMode == constants.EntrypointChain && len(bo.cmd) > 0 {
+		return fmt.Errorf("--cmd cannot be used with --entrypoint-mode chain, the cmd runs start.sh")
+	}
+
+	return
+}
+
+func (bo *BuildOptions) build() (err error) {
+	ctx := context.TODO()
+	startedOn := time.Now().UTC()
+	currWorkDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	if bo.dryRun {
+		return bo.planBuild(ctx, currWorkDir, startedOn)
+	}
+
+	lockFilePath := filepath.Join(currWorkDir, constants.MetaDirName, constants.LockFileName)
+	workspaceLocked := lock.LockFile(lockFilePath)
+	if !workspaceLocked {
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("\ntry to lock workspace failed, please wait or clean workspace\n"))
+		return
+	}
+
+	defer lock.UnlockFile(lockFilePath)
+	defer bo.pruneLayerCache(startedOn)
+
+	if len(bo.matrixEntries) > 0 {
+		return bo.buildMatrix(ctx, currWorkDir, startedOn)
+	}
+
+	result, err := bo.buildImage(ctx, currWorkDir, startedOn, nil)
+	if err != nil {
+		return
+	}
+
+	err = bo.updateImageInfo(result.imageRef, &server.BaseImageInfo{Image: result.BaseImage, Digest: result.BaseDigest})
+	if err != nil {
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("export server.yaml failed, %v\n", err))
+	}
+	return
+}
+
+// BuildResult describes an image pushed by a build
+type BuildResult struct {
+	DeviceType string   `json:"deviceType"`
+	Platform   string   `json:"platform"`
+	BaseImage  string   `json:"baseImage"`
+	BaseDigest string   `json:"baseDigest"`
+	Image      string   `json:"image"`
+	Digest     string   `json:"digest"`
+	Tags       []string `json:"tags,omitempty"`
+
+	imageRef imageref.ImageRef
+}
+
+// buildImage renders the serving scripts for the device type and pushes the image with its tags and attestations.
+// Without model the whole workspace is packed, otherwise the shared model layers are followed by a layer holding
+// only the generated serving scripts
+func (bo *BuildOptions) buildImage(ctx context.Context, currWorkDir string, startedOn time.Time, model []packedLayer) (result BuildResult, err error) {
+	targetTemplate := bo.targetImage
+	err = bo.renderTags(currWorkDir, startedOn)
+	if err != nil {
+		return
+	}
+
+	if !bo.skipScript {
+		err = bo.renderServingServer(currWorkDir)
+		if err != nil {
+			utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("generate serving_server.py failed, err: %v\n", err))
+			return
+		}
+
+		utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("serving_server.py generated successfully!\n"))
+	} else {
+		fileExist := bo.checkServingServerFile(currWorkDir)
+		if !fileExist {
+			err = fmt.Errorf("must provide serving_server.py yourself")
+			return
+		}
+	}
+
+	startScriptPath, err := bo.createStartScript(currWorkDir)
+	if err != nil {
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("generate image started script failed, err: %v\n", err))
+		return
+	}
+
+	pf, rSrc, rTgt, imageRef, rTags, err := bo.resolveRefs()
+	if err != nil {
+		return
+	}
+
+	rc := bo.rootOpts.newRegClient()
+	defer rc.Close(ctx, rSrc)
+
+	baseImage, err := modelimage.Get(ctx, rc, rSrc, pf)
+	if err != nil {
+		err = fmt.Errorf("failed to read base image %s: %w", rSrc.CommonName(), err)
+		return
+	}
+
+	baseImage.Limiter, err = bo.rootOpts.rateLimiter(rSrc.Registry, bo.limitRate)
+	if err != nil {
+		return
+	}
+
+	err = bo.pinBaseImage(currWorkDir, baseImage.Digest)
+	if err != nil {
+		return
+	}
+
+	baseConfig := bo.startConfig(ctx, rc, pf, baseImage)
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("base image entrypoint: %s, cmd: %s\n", formatCommand(baseConfig.Entrypoint), formatCommand(baseConfig.Cmd)))
+	if bo.entrypointMode == constants.EntrypointReplace && len(baseConfig.Entrypoint) > 0 {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("the base image entrypoint is replaced by start.sh, use --entrypoint-mode chain to run it before start.sh\n"))
+	}
+
+	shell, err := bo.checkStartShell(ctx, rc, currWorkDir, baseImage)
+	if err != nil {
+		return
+	}
+	if shell == "" {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("the base image entrypoint is kept, run %s to start the model\n", startScriptPath))
+	}
+
+	baseLayers := 0
+	if baseImage.IsPackctlImage() {
+		baseLayers = baseImage.PackctlLayerCount()
+	}
+
+	packedLayers := []packedLayer{}
+	if model == nil {
+		var workspaceSize int64
+		workspaceSize, err = getDirectorySize(currWorkDir)
+		if err != nil {
+			return
+		}
+
+		packedLayers, err = bo.packLayers(ctx, currWorkDir, "pack workspace", "packctl build "+bo.targetImage, workspaceSize,
+			archive.TarWhiteouts(bo.removePaths...))
+		if err != nil {
+			return
+		}
+	} else {
+		var profile packedLayer
+		profile, err = bo.packLayer(ctx, currWorkDir, "pack device profile", fmt.Sprintf("packctl build %s --deviceType %s", bo.targetImage, bo.deviceType),
+			0, archive.TarInclude(deviceProfileFiles...), archive.TarWhiteouts(bo.removePaths...))
+		if err != nil {
+			return
+		}
+		// the model layers are packed once for the matrix, their history names the target image of this entry
+		for _, layer := range model {
+			layer.layer.CreatedBy = strings.Replace(layer.layer.CreatedBy, targetTemplate, bo.targetImage, 1)
+			packedLayers = append(packedLayers, layer)
+		}
+		packedLayers = append(packedLayers, profile)
+	}
+
+	dropLayers := 0
+	if bo.squash {
+		var squashed packedLayer
+		squashed, err = bo.squashLayers(ctx, rc, baseImage, baseLayers, packedLayers)
+		if err != nil {
+			return
+		}
+
+		packedLayers = []packedLayer{squashed}
+		dropLayers, baseLayers = baseLayers, 0
+	}
+
+	layers := []modelimage.Layer{}
+	fileDigests := map[string]digest.Digest{}
+	for _, packed := range packedLayers {
+		err = bo.pushLayer(ctx, rc, rTgt, packed)
+		if err != nil {
+			return
+		}
+
+		layers = append(layers, packed.layer)
+		for name, dig := range packed.files {
+			fileDigests[name] = dig
+		}
+	}
+
+	// the SBOM and provenance list the files split by --max-layer-size instead of their parts
+	err = archive.JoinPartDigests(currWorkDir, fileDigests)
+	if err != nil {
+		return
+	}
+
+	baseName, baseDigest := rSrc.CommonName(), baseImage.Digest.String()
+	if dropLayers > 0 {
+		// the layers of earlier packctl builds are squashed, the image is built on the image they started from
+		var origin *modelimage.Image
+		origin, err = baseImage.BuiltOn(ctx, rc, pf)
+		if err != nil {
+			err = fmt.Errorf("failed to read the image %s was built on: %w", rSrc.CommonName(), err)
+			return
+		}
+		rOrigin := origin.Ref
+		if rOrigin.Tag != "" {
+			rOrigin = rOrigin.SetTag(rOrigin.Tag)
+		}
+		baseName, baseDigest = rOrigin.CommonName(), origin.Digest.String()
+	}
+
+	change := bo.imageChange(currWorkDir, startScriptPath, baseLayers+len(layers), baseConfig)
+	change.Layers = layers
+	change.DropLayers = dropLayers
+	change.Download = baseImage.Limiter
+	change.Upload, err = bo.rootOpts.rateLimiter(rTgt.Registry, bo.limitRate)
+	if err != nil {
+		return
+	}
+	change.Annotations = map[string]string{
+		types.AnnotationBaseImageName:   baseName,
+		types.AnnotationBaseImageDigest: baseDigest,
+	}
+
+	rOut, err := modelimage.Append(ctx, rc, rSrc.SetDigest(baseImage.Digest.String()), rTgt, pf, change)
+	if err != nil {
+		return
+	}
+
+	// the first error of the build is kept over a failure to close the ref
+	defer func() {
+		if cerr := rc.Close(ctx, rOut); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to close ref: %w", cerr)
+		}
+	}()
+
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("image %s pushed to registry successfully\n", rOut.CommonName()))
+	err = bo.pushTags(ctx, rc, rOut, rTags)
+	if err != nil {
+		return
+	}
+
+	m, err := rc.ManifestHead(ctx, rOut, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	result = BuildResult{
+		DeviceType: bo.deviceType,
+		Platform:   bo.platforms,
+		BaseImage:  bo.baseImage,
+		BaseDigest: baseImage.Digest.String(),
+		Image:      rOut.CommonName(),
+		Digest:     m.GetDescriptor().Digest.String(),
+		imageRef:   imageRef,
+	}
+	for _, rTag := range rTags {
+		result.Tags = append(result.Tags, rTag.CommonName())
+	}
+
+	if bo.signer != nil {
+		err = bo.signImage(ctx, rOut, rTags)
+		if err != nil {
+			return
+		}
+	}
+
+	if bo.provenance {
+		err = bo.attachProvenance(ctx, rc, rOut, rSrc, baseImage.Digest, fileDigests, startedOn)
+		if err != nil {
+			err = fmt.Errorf("failed to attach provenance: %w", err)
+			return
+		}
+	}
+
+	if bo.sbom || bo.pushSBOM {
+		err = bo.writeSBOM(ctx, rc, currWorkDir, rOut, rSrc, baseImage.Digest, fileDigests)
+		if err != nil {
+			err = fmt.Errorf("failed to generate sbom: %w", err)
+			return
+		}
+	}
+
+	return
+}
+
+// imageChange returns the config changes of the image, packctlLayers counts the layers added by packctl builds.
+// The entrypoint mode decides how start.sh is combined with the entrypoint of base
+func (bo *BuildOptions) imageChange(currWorkDir, startScriptPath string, packctlLayers int, base v1.ImageConfig) modelimage.Change {
+	labels := map[string]string{}
+	for name, value := range bo.labels {
+		labels[name] = value
+	}
+	labels[constants.LabelWorkspace] = currWorkDir
+	labels[constants.LabelLayers] = strconv.Itoa(packctlLayers)
+
+	change := modelimage.Change{
+		Env:        bo.env,
+		Ports:      bo.ports,
+		WorkingDir: bo.workingDir,
+		User:       bo.user,
+		StopSignal: bo.stopSignal,
+		Labels:     labels,
+	}
+	// without --cmd the cmd of the base image is kept
+	if len(bo.cmd) > 0 {
+		change.Cmd = bo.cmd
+	}
+
+	start := []string{constants.StartShell, "-c", startScriptPath}
+	switch bo.entrypointMode {
+	case constants.EntrypointKeep:
+	case constants.EntrypointChain:
+		// without a base entrypoint there is nothing to chain, start.sh runs on its own
+		if len(base.Entrypoint) > 0 {
+			change.Entrypoint = base.Entrypoint
+			change.Cmd = start
+		} else {
+			change.Entrypoint = start
+		}
+	default:
+		change.Entrypoint = start
+	}
+
+	// base may differ from the config of a packctl base image, whose entrypoint or cmd run the start.sh of its build
+	if change.Entrypoint == nil {
+		change.Entrypoint = append([]string{}, base.Entrypoint...)
+	}
+	if change.Cmd == nil {
+		change.Cmd = append([]string{}, base.Cmd...)
+	}
+
+	return change
+}
+
+// startConfig returns the config of the base image with the entrypoint and cmd start.sh is combined with. For a
+// packctl base image these are the entrypoint and cmd of the image its builds started from, so the start.sh of an
+// earlier build is neither chained nor kept
+func (bo *BuildOptions) startConfig(ctx context.Context, rc *regclient.RegClient, pf platform.Platform, baseImage *modelimage.Image) v1.ImageConfig {
+	config := baseImage.Config.GetConfig().Config
+	if !baseImage.IsPackctlImage() {
+		return config
+	}
+
+	origin, err := baseImage.BuiltOn(ctx, rc, pf)
+	if err != nil {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("the entrypoint and cmd of %s are used, failed to read the image it was built on: %v\n", bo.baseImage, err))
+		return config
+	}
+
+	originConfig := origin.Config.GetConfig().Config
+	config.Entrypoint, config.Cmd = originConfig.Entrypoint, originConfig.Cmd
+	return config
+}
+
+// resolveRefs parses the platform, the base image and the rendered target image and tags
+func (bo *BuildOptions) resolveRefs() (pf platform.Platform, rSrc, rTgt ref.Ref, imageRef imageref.ImageRef, rTags []ref.Ref, err error) {
+	pf, err = platform.Parse(bo.platforms)
+	if err != nil {
+		err = fmt.Errorf("failed to parse platform %s: %v", bo.platforms, err)
+		return
+	}
+
+	rSrc, err = ref.New(bo.baseImage)
+	if err != nil {
+		return
+	}
+
+	if strings.ContainsAny(bo.targetImage, "/:") {
+		rTgt, err = ref.New(bo.targetImage)
+		if err != nil {
+			err = fmt.Errorf("failed to parse new image name %s: %w", bo.targetImage, err)
+			return
+		}
+	} else {
+		rTgt = rSrc.SetTag(bo.targetImage)
+	}
+
+	imageRef, err = imageref.NewImageRef(rTgt.CommonName())
+	if err != nil {
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("targetImage [%s] reference invalid\n", bo.targetImage))
+		return
+	}
+
+	rTags, err = bo.tagRefs(rTgt)
+	return
+}
+
+// renderTags executes the templates in the target image and --tag values and drops duplicate tags.
+// Matrix builds suffix the tags with the device type unless the template already uses it
+func (bo *BuildOptions) renderTags(currWorkDir string, startedOn time.Time) (err error) {
+	params := imageref.TagParams{
+		DeviceType: bo.deviceType,
+		Platform:   bo.platforms,
+		GitSHA:     gitCommit(currWorkDir),
+		Date:       startedOn.Format("20060102"),
+	}
+
+	serverConfig, err := server.ReadServerFile(filepath.Join(currWorkDir, constants.MetaDirName, constants.ServerConfigFile))
+	if err == nil {
+		params.Name = serverConfig.Name
+		params.Version = serverConfig.Version
+	}
+
+	render := func(tmpl string) (string, error) {
+		rendered, err := imageref.RenderTag(tmpl, params)
+		if err != nil || bo.matrixDeviceType == "" || strings.Contains(tmpl, ".DeviceType") {
+			return rendered, err
+		}
+
+		return tagWithSuffix(rendered, "-"+strings.ToLower(bo.matrixDeviceType)), nil
+	}
+
+	bo.targetImage, err = render(bo.targetImage)
+	if err != nil {
+		return
+	}
+
+	seen := map[string]bool{bo.targetImage: true}
+	tags := []string{}
+	for _, tag := range bo.tags {
+		tag, err = render(tag)
+		if err != nil {
+			return
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+
+	bo.tags = tags
+	return
+}
+
+// tagRefs resolves the --tag values, a plain tag refers to the repository of rTgt
+func (bo *BuildOptions) tagRefs(rTgt ref.Ref) (rTags []ref.Ref, err error) {
+	for _, tag := range bo.tags {
+		rTag := rTgt.SetTag(tag)
+		if strings.ContainsAny(tag, "/:") {
+			rTag, err = ref.New(tag)
+		}
+		if err == nil {
+			_, err = imageref.NewImageRef(rTag.CommonName())
+		}
+		if err == nil && rTag.Digest != "" {
+			err = fmt.Errorf("tag must not contain a digest")
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid tag %s: %w", tag, err)
+			return
+		}
+		if rTag.CommonName() == rTgt.CommonName() {
+			continue
+		}
+
+		rTags = append(rTags, rTag)
+	}
+
+	return
+}
+
+// pushTags publishes the pushed image under every tag, only the manifests are pushed within the same repository
+func (bo *BuildOptions) pushTags(ctx context.Context, rc *regclient.RegClient, rOut ref.Ref, rTags []ref.Ref) (err error) {
+	if len(rTags) == 0 {
+		return
+	}
+
+	m, err := rc.ManifestHead(ctx, rOut, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	download, err := bo.rootOpts.rateLimiter(rOut.Registry, bo.limitRate)
+	if err != nil {
+		return
+	}
+
+	rDigest := rOut.SetDigest(m.GetDescriptor().Digest.String())
+	for _, rTag := range rTags {
+		var upload *ratelimit.Limiter
+		upload, err = bo.rootOpts.rateLimiter(rTag.Registry, bo.limitRate)
+		if err != nil {
+			return
+		}
+
+		err = modelimage.Copy(ctx, rc, rDigest, rTag, download, upload)
+		if err != nil {
+			err = fmt.Errorf("failed to tag %s as %s: %w", rOut.CommonName(), rTag.CommonName(), err)
+			return
+		}
+
+		utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("image %s tagged as %s\n", rOut.CommonName(), rTag.CommonName()))
+	}
+
+	return
+}
+
+// gitCommit returns the short commit of the git repository containing dir, or an empty string
+func gitCommit(dir string) string {
+	output, err := exec.Command("git", "-C", dir, "rev-parse", "--short=12", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// packedLayer is a layer spooled into the layer cache together with the digests of the files in it
+type packedLayer struct {
+	entry layercache.Entry
+	layer modelimage.Layer
+	files map[string]digest.Digest
+}
+
+// packLayer packs the workspace into the layer cache, opts select the files of the layer and size is their
+// expected size for the progress output, 0 when unknown
+func (bo *BuildOptions) packLayer(ctx context.Context, currWorkDir, name, createdBy string, size int64, opts ...archive.TarOpts) (packed packedLayer, err error) {
+	cache, _, err := bo.layerCache()
+	if err != nil {
+		return
+	}
+
+	packed.files = map[string]digest.Digest{}
+	opts = append(append([]archive.TarOpts{archive.TarDigests(packed.files), archive.TarDirTime(time.Unix(0, 0).UTC())}, bo.workspaceTarOpts()...), opts...)
+	tarTask := bo.progress.Start(name, size)
+	packed.entry, err = cache.Spool(func(w io.Writer) error {
+		return archive.Tar(ctx, currWorkDir, io.MultiWriter(w, tarTask.Writer()), opts...)
+	}, archive.CompressThreads(bo.compressionThreads))
+	tarTask.Done()
+	if err != nil {
+		err = fmt.Errorf("failed to pack workspace: %w", err)
+		return
+	}
+
+	packed.layer = modelimage.Layer{
+		Descriptor: packed.entry.Descriptor(),
+		DiffID:     packed.entry.DiffID,
+		CreatedBy:  createdBy,
+	}
+	return
+}
+
+// squashLayers merges the packctl layers of the base image, baseLayers of them, and the packed layers into a single
+// layer replacing them all. A single layer is returned unchanged
+func (bo *BuildOptions) squashLayers(ctx context.Context, rc *regclient.RegClient, baseImage *modelimage.Image, baseLayers int, packed []packedLayer) (squashed packedLayer, err error) {
+	total := baseLayers + len(packed)
+	if total == 1 {
+		utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("the build adds a single layer, nothing to squash\n"))
+		return packed[0], nil
+	}
+
+	cache, _, err := bo.layerCache()
+	if err != nil {
+		return
+	}
+
+	// Squash reads every layer twice, the layers of the base image are downloaded to the cache once
+	entries := []layercache.Entry{}
+	if baseLayers > 0 {
+		for _, layer := range baseImage.PackctlLayers() {
+			var entry layercache.Entry
+			entry, err = bo.cacheBaseLayer(ctx, rc, cache, baseImage, layer)
+			if err != nil {
+				err = fmt.Errorf("failed to download layer %s: %w", layer.Digest, err)
+				return
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	squashed.files = map[string]digest.Digest{}
+	for _, layer := range packed {
+		entries = append(entries, layer.entry)
+		for name, dig := range layer.files {
+			squashed.files[name] = dig
+		}
+	}
+
+	sources := []modelimage.LayerSource{}
+	for _, entry := range entries {
+		entry := entry
+		sources = append(sources, func() (io.ReadCloser, error) {
+			return entry.Open()
+		})
+	}
+
+	squashTask := bo.progress.Start(fmt.Sprintf("squash %d layers", total), 0)
+	squashed.entry, err = cache.Spool(func(w io.Writer) error {
+		return modelimage.Squash(ctx, io.MultiWriter(w, squashTask.Writer()), sources)
+	}, archive.CompressThreads(bo.compressionThreads))
+	squashTask.Done()
+	if err != nil {
+		err = fmt.Errorf("failed to squash layers: %w", err)
+		return
+	}
+
+	squashed.layer = modelimage.Layer{
+		Descriptor: squashed.entry.Descriptor(),
+		DiffID:     squashed.entry.DiffID,
+		CreatedBy:  fmt.Sprintf("%s --squash (%d layers)", packed[len(packed)-1].layer.CreatedBy, total),
+	}
 
-	return command
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("%d layers squashed into layer %s, %d bytes\n", total, squashed.entry.Digest, squashed.entry.Size))
+	return
 }
 
-func (bo *BuildOptions) validate() error {
-	switch strings.ToLower(bo.deviceType) {
-	default:
-		return fmt.Errorf("unknown deviceType [%s]", bo.deviceType)
-	case "cpu":
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("deviceType is CPU now\n"))
-	case "ascend", "gpu":
+// cacheBaseLayer returns the cached copy of a layer of the base image, downloading it when missing
+func (bo *BuildOptions) cacheBaseLayer(ctx context.Context, rc *regclient.RegClient, cache *layercache.Cache, baseImage *modelimage.Image, layer descriptor.Descriptor) (entry layercache.Entry, err error) {
+	entry, ok := cache.Lookup(layer.Digest)
+	if ok {
+		return
 	}
 
-	if bo.baseImage == "" {
-		return fmt.Errorf("base image cannnot be empty!")
+	rdr, err := rc.BlobGet(ctx, baseImage.Ref, layer)
+	if err != nil {
+		return
 	}
+	defer rdr.Close()
 
-	if bo.skipScript {
-		utils.PrintYellow(os.Stdout, fmt.Sprintf("please provide serving_server.py yourself\n"))
+	downloadTask := bo.progress.Start("download layer "+layer.Digest.Encoded()[:12], layer.Size)
+	entry, err = cache.Store(layer.Digest, io.TeeReader(baseImage.Limiter.Reader(ctx, rdr), downloadTask.Writer()))
+	downloadTask.Done()
+	return
+}
+
+// pruneLayerCache removes the least recently used layers from the cache once everything is pushed, the layers of this
+// build and of builds running next to it are kept. Failing to prune only prints a warning
+func (bo *BuildOptions) pruneLayerCache(startedOn time.Time) {
+	cache, cacheDir, err := bo.layerCache()
+	if err == nil {
+		err = cache.Prune(layercache.DefaultKeep, startedOn.Add(-layercache.DefaultGrace))
+	}
+	if err != nil {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("failed to prune layer cache %s: %v\n", cacheDir, err))
 	}
+}
 
-	return nil
+// layerCache opens the layer cache in --cache-dir, default ~/.packctl/cache
+func (bo *BuildOptions) layerCache() (cache *layercache.Cache, cacheDir string, err error) {
+	cacheDir = bo.cacheDir
+	if cacheDir == "" {
+		cacheDir, err = layercache.DefaultDir()
+		if err != nil {
+			return
+		}
+	}
+
+	cache, err = layercache.New(cacheDir)
+	return
 }
 
-func (bo *BuildOptions) build() (err error) {
-	imageRef, err := imageref.NewImageRef(bo.targetImage)
+// packLayers packs the workspace like packLayer, split into layers of at most --max-layer-size when it is set.
+// Whiteouts in opts only go into the first layer
+func (bo *BuildOptions) packLayers(ctx context.Context, currWorkDir, name, createdBy string, size int64, opts ...archive.TarOpts) (packed []packedLayer, err error) {
+	maxSize, err := bo.layerLimit()
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("targetImage [%s] reference invalid\n", bo.targetImage))
 		return
 	}
 
-	ctx := context.TODO()
-	currWorkDir, err := os.Getwd()
+	if maxSize == 0 {
+		var layer packedLayer
+		layer, err = bo.packLayer(ctx, currWorkDir, name, createdBy, size, opts...)
+		return []packedLayer{layer}, err
+	}
+
+	entries, err := archive.List(ctx, currWorkDir, append(bo.workspaceTarOpts(), opts...)...)
 	if err != nil {
 		return
 	}
 
-	lockFilePath := filepath.Join(currWorkDir, constants.MetaDirName, constants.LockFileName)
-	workspaceLocked := lock.LockFile(lockFilePath)
-	if !workspaceLocked {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("\ntry to lock workspace failed, please wait or clean workspace\n"))
-		return
+	sizes := map[string]int64{}
+	for _, entry := range entries {
+		sizes[entry.Name] = entry.Size
 	}
 
-	defer lock.UnlockFile(lockFilePath)
+	// gzip grows data it cannot compress a little, the tar is split with some headroom
+	splits := archive.SplitLayers(entries, maxSize-maxSize/64)
+	for i, files := range splits {
+		var layerSize int64
+		for fileName, parts := range files {
+			if len(parts) == 0 {
+				layerSize += sizes[fileName]
+			}
+			for _, part := range parts {
+				layerSize += part.Size
+			}
+		}
 
-	if !bo.skipScript {
-		err = bo.renderServingServer(currWorkDir)
+		layerOpts := append(opts[:len(opts):len(opts)], archive.TarFiles(files))
+		if i > 0 {
+			layerOpts = append(layerOpts, archive.TarWhiteouts())
+		}
+
+		var layer packedLayer
+		layer, err = bo.packLayer(ctx, currWorkDir, fmt.Sprintf("%s %d/%d", name, i+1, len(splits)), fmt.Sprintf("%s (layer %d/%d)", createdBy, i+1, len(splits)),
+			layerSize, layerOpts...)
 		if err != nil {
-			utils.PrintWarning(os.Stdout, fmt.Sprintf("generate serving_server.py failed, err: %v\n", err))
 			return
 		}
 
-		utils.PrintString(os.Stdout, fmt.Sprintf("serving_server.py generated successfully!\n"))
-	} else {
-		fileExist := bo.checkServingServerFile(currWorkDir)
-		if !fileExist {
-			utils.PrintWarning(os.Stdout, fmt.Sprintf("must provide serving_server.py yourself!\n"))
+		if layer.entry.Size > maxSize {
+			err = fmt.Errorf("layer %d/%d is %d bytes, larger than --max-layer-size %s", i+1, len(splits), layer.entry.Size, bo.maxLayerSize)
 			return
 		}
+		packed = append(packed, layer)
 	}
 
-	startScriptPath, err := bo.createStartScript(currWorkDir)
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("workspace split into %d layers of at most %s\n", len(packed), bo.maxLayerSize))
+	return
+}
+
+// layerLimit returns --max-layer-size in bytes, 0 when the workspace is packed into a single layer
+func (bo *BuildOptions) layerLimit() (int64, error) {
+	if bo.maxLayerSize == "" {
+		return 0, nil
+	}
+	return server.ParseMaxLayerSize(bo.maxLayerSize)
+}
+
+// workspaceTarOpts excludes the files that never go into a layer and applies the layer options to the others
+func (bo *BuildOptions) workspaceTarOpts() []archive.TarOpts {
+	return append([]archive.TarOpts{
+		archive.TarExclude(path.Join(constants.MetaDirName, constants.LockFileName), path.Join(constants.MetaDirName, sbom.FileName)),
+		archive.TarIgnore(bo.ignore...),
+	}, bo.layerOpts...)
+}
+
+// pushLayer uploads a packed layer to the rTgt repository unless it is already there
+func (bo *BuildOptions) pushLayer(ctx context.Context, rc *regclient.RegClient, rTgt ref.Ref, packed packedLayer) (err error) {
+	entry := packed.entry
+	if entry.Exists(ctx, rc, rTgt) {
+		utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("layer %s already exists in %s, upload skipped\n", entry.Digest, rTgt.CommonName()))
+		return
+	}
+
+	err = bo.uploadLayer(ctx, rTgt, entry)
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("generate image started script failed, err: %v\n", err))
+		err = fmt.Errorf("failed to push layer %s: %w", entry.Digest, err)
 		return
 	}
 
-	var rdr io.Reader
-	var platforms []platform.Platform
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("layer %s pushed, %d bytes\n", entry.Digest, entry.Size))
+	return
+}
 
-	pf, err := platform.Parse(bo.platforms)
+// uploadLayer pushes the cached layer in chunks, resuming after connection failures
+func (bo *BuildOptions) uploadLayer(ctx context.Context, rTgt ref.Ref, entry layercache.Entry) (err error) {
+	limiter, err := bo.rootOpts.rateLimiter(rTgt.Registry, bo.limitRate)
 	if err != nil {
-		err = fmt.Errorf("failed to parse platform %s: %v", bo.platforms, err)
 		return
 	}
 
-	platforms = append(platforms, pf)
+	uploadTask := bo.progress.Start("upload layer "+entry.Digest.Encoded()[:12], entry.Size)
+	uploader, err := upload.New(bo.rootOpts.hostConfig(rTgt.Registry), upload.Options{
+		ChunkSize: bo.chunkSize,
+		Retries:   bo.uploadRetries,
+		Backoff:   bo.uploadBackoff,
+		UserAgent: bo.rootOpts.userAgentString(),
+		Notify: func(status upload.Status) {
+			if status.Err != nil || status.Resumed {
+				uploadTask.Clear()
+			}
+			uploadTask.Set(status.Uploaded)
+			switch {
+			case status.Err != nil:
+				utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("upload of layer %s interrupted at %d/%d bytes: %v, retrying in %s (%d/%d)\n",
+					status.Digest, status.Uploaded, status.Size, status.Err, status.Wait, status.Attempt, bo.uploadRetries))
+			case status.Resumed:
+				utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("resuming upload of layer %s at %d/%d bytes\n", status.Digest, status.Uploaded, status.Size))
+			}
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	fh, err := entry.Open()
+	if err != nil {
+		return
+	}
+
+	defer fh.Close()
+	err = uploader.Upload(ctx, rTgt, entry.Descriptor(), progressReaderAt{r: limiter.ReaderAt(ctx, fh), task: uploadTask})
+	if err != nil {
+		uploadTask.Clear()
+		return
+	}
+
+	uploadTask.Done()
+	return
+}
+
+// progressReaderAt reports the furthest offset read as the progress of an upload
+type progressReaderAt struct {
+	r    io.ReaderAt
+	task *progress.Task
+}
+
+func (pr progressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := pr.r.ReadAt(p, off)
+	pr.task.Set(off + int64(n))
+	return n, err
+}
 
-	pr, pw := io.Pipe()
-	go func() {
-		err := archive.Tar(context.TODO(), currWorkDir, pw)
+// getDirectorySize sums the sizes of the regular files below dir
+func getDirectorySize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
 		if err != nil {
-			_ = pw.CloseWithError(err)
+			return err
 		}
-		_ = pw.Close()
-	}()
-	rdr = pr
-	defer pr.Close()
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// pinBaseImage checks the resolved base image digest against .modelmesh/packctl.lock in locked mode,
+// otherwise it records the digest in the lock file
+func (bo *BuildOptions) pinBaseImage(currWorkDir string, baseDigest digest.Digest) (err error) {
+	lockFilePath := filepath.Join(currWorkDir, constants.MetaDirName, constants.PackctlLockFile)
+	if !bo.locked {
+		return writeBaseLock(lockFilePath, bo.matrixDeviceType, bo.baseImage, baseDigest)
+	}
+
+	updateBase := "packctl update-base"
+	if bo.matrixDeviceType != "" {
+		updateBase += " --deviceType " + bo.matrixDeviceType
+	}
+
+	lockFile, err := server.ReadLockFile(lockFilePath)
+	if os.IsNotExist(err) {
+		err = fmt.Errorf("%s not found, run %s to pin the base image", lockFilePath, updateBase)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("failed to read %s: %w", lockFilePath, err)
+		return
+	}
+
+	base, ok := lockFile.GetBase(bo.matrixDeviceType)
+	if !ok {
+		err = fmt.Errorf("no base image pinned for deviceType %s in %s, run %s to pin it", bo.matrixDeviceType, lockFilePath, updateBase)
+		return
+	}
+
+	if base.Image != bo.baseImage {
+		err = fmt.Errorf("base image %s differs from locked base image %s, run %s to change it", bo.baseImage, base.Image, updateBase)
+		return
+	}
+
+	if base.Digest != baseDigest.String() {
+		err = fmt.Errorf("base image %s now resolves to %s instead of locked %s, run %s to accept it", bo.baseImage, baseDigest, base.Digest, updateBase)
+		return
+	}
+
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("base image %s locked to %s\n", bo.baseImage, baseDigest))
+	return
+}
+
+// checkStartShell makes sure the base image can run start.sh and returns the path the shell resolved to, empty when
+// the entrypoint is kept. The result is recorded in packctl.lock so the base layers are only read once per base digest
+func (bo *BuildOptions) checkStartShell(ctx context.Context, rc *regclient.RegClient, currWorkDir string, baseImage *modelimage.Image) (shell string, err error) {
+	if bo.entrypointMode == constants.EntrypointKeep {
+		return
+	}
 
-	rSrc, err := ref.New(bo.baseImage)
+	lockFilePath := filepath.Join(currWorkDir, constants.MetaDirName, constants.PackctlLockFile)
+	lockFile, lockErr := server.ReadLockFile(lockFilePath)
+	base, pinned := server.BaseImageLock{}, false
+	if lockErr == nil {
+		base, pinned = lockFile.GetBase(bo.matrixDeviceType)
+		pinned = pinned && base.Digest == baseImage.Digest.String()
+	}
+	if pinned && base.Shell != "" {
+		return base.Shell, bo.checkShellKept(base.Shell)
+	}
+
+	// a dry run stays cheap, finding the shell may download most of the base image
+	if bo.dryRun {
+		utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("the base image is not checked for %s in a dry run\n", constants.StartShell))
+		return "", bo.checkShellKept(constants.StartShell)
+	}
+
+	task := bo.progress.Start("check base image for "+constants.StartShell, 0)
+	hdr, err := baseImage.Lookup(ctx, rc, constants.StartShell, task.Writer())
+	task.Done()
 	if err != nil {
+		err = fmt.Errorf("failed to check base image %s for %s: %w", bo.baseImage, constants.StartShell, err)
 		return
 	}
 
-	var rTgt ref.Ref
-	if strings.ContainsAny(bo.targetImage, "/:") {
-		rTgt, err = ref.New(bo.targetImage)
+	if hdr == nil || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeLink) || hdr.Mode&0111 == 0 {
+		err = fmt.Errorf("base image %s has no executable %s to run start.sh, use a base image with bash or --entrypoint-mode keep", bo.baseImage, constants.StartShell)
+		return
+	}
+
+	shell = hdr.Name
+	err = bo.checkShellKept(shell)
+	if err != nil {
+		return
+	}
+
+	if pinned && !bo.locked && !bo.dryRun {
+		base.Shell = shell
+		lockFile.SetBase(bo.matrixDeviceType, base)
+		err = server.WriteLockFile(lockFilePath, lockFile)
 		if err != nil {
-			err = fmt.Errorf("failed to parse new image name %s: %w", bo.targetImage, err)
-			return
+			err = fmt.Errorf("failed to write %s: %w", lockFilePath, err)
+		}
+	}
+
+	return
+}
+
+// checkShellKept fails when --remove-path hides the start shell or the file it resolves to
+func (bo *BuildOptions) checkShellKept(shell string) error {
+	for _, name := range []string{constants.StartShell, shell} {
+		if removePath := bo.removedBy(name); removePath != "" {
+			return fmt.Errorf("--remove-path %s removes %s that runs start.sh, use --entrypoint-mode keep to remove it", removePath, name)
 		}
-	} else {
-		rTgt = rSrc.SetTag(bo.targetImage)
+	}
+	return nil
+}
+
+// formatCommand formats an entrypoint or cmd in the exec form of a Dockerfile
+func formatCommand(command []string) string {
+	if len(command) == 0 {
+		return "[]"
 	}
 
-	modOptions := []mod.Opts{}
+	quoted := []string{}
+	for _, arg := range command {
+		quoted = append(quoted, strconv.Quote(arg))
+	}
 
-	modOptions = append(modOptions,
-		mod.WithLayerAddTar(rdr, "", platforms),
-		mod.WithRefTgt(rTgt),
-		mod.WithConfigEntrypoint([]string{"/bin/bash", "-c", startScriptPath}),
-	)
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
 
-	rc := bo.rootOpts.newRegClient()
-	defer rc.Close(ctx, rSrc)
-	rOut, err := mod.Apply(ctx, rc, rSrc, modOptions...)
+// writeBaseLock records the base image digest of deviceType, or of the single base image when deviceType is empty.
+// An unchanged pin is left untouched
+func writeBaseLock(lockFilePath, deviceType, baseImage string, baseDigest digest.Digest) error {
+	lockFile, err := server.ReadLockFile(lockFilePath)
+	if err != nil {
+		lockFile = &server.LockFile{}
+	}
+
+	base, _ := lockFile.GetBase(deviceType)
+	if err == nil && base.Image == baseImage && base.Digest == baseDigest.String() {
+		return nil
+	}
+
+	lockFile.SetBase(deviceType, server.BaseImageLock{
+		Image:      baseImage,
+		Digest:     baseDigest.String(),
+		ResolvedAt: time.Now().UTC(),
+	})
+	return server.WriteLockFile(lockFilePath, lockFile)
+}
+
+// attachProvenance pushes a SLSA provenance attestation describing the build of rOut
+func (bo *BuildOptions) attachProvenance(ctx context.Context, rc *regclient.RegClient, rOut, rSrc ref.Ref, baseDigest digest.Digest, files map[string]digest.Digest, startedOn time.Time) (err error) {
+	m, err := rc.ManifestHead(ctx, rOut, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	dig := m.GetDescriptor().Digest
+	statement := provenance.NewStatement(provenance.Build{
+		Image:        signature.Repository(rOut),
+		ImageDigest:  dig,
+		BaseImage:    rSrc.CommonName(),
+		BaseDigest:   baseDigest,
+		Parameters:   bo.buildParameters(),
+		Files:        files,
+		StartedOn:    startedOn,
+		FinishedOn:   time.Now().UTC(),
+		ToolVersions: version.GetInfo(),
+	})
+
+	statementBytes, err := statement.Marshal()
+	if err != nil {
+		return
+	}
+
+	env, err := signature.NewEnvelope(statementBytes, bo.signer)
+	if err != nil {
+		return
+	}
+
+	err = signature.AttachAttestation(ctx, rc, rOut, dig, provenance.PredicateSLSA, env)
+	if err != nil {
+		return
+	}
+
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("provenance attestation attached to %s@%s\n", rOut.CommonName(), dig))
+	return
+}
+
+// writeSBOM writes the SBOM of the files added to rOut into the workspace and optionally pushes it as a referrer
+func (bo *BuildOptions) writeSBOM(ctx context.Context, rc *regclient.RegClient, currWorkDir string, rOut, rSrc ref.Ref, baseDigest digest.Digest, files map[string]digest.Digest) (err error) {
+	serverConfig := &server.ServerFile{}
+	serverConfigBytes, err := os.ReadFile(filepath.Join(currWorkDir, constants.MetaDirName, constants.ServerConfigFile))
+	if err == nil {
+		err = yaml.Unmarshal(serverConfigBytes, serverConfig)
+	}
+	if err != nil {
+		return
+	}
+
+	servableLicenses := map[string]string{}
+	for _, servable := range serverConfig.Servables {
+		if servable.License != "" {
+			servableLicenses[servable.Name] = servable.License
+		}
+	}
+
+	m, err := rc.ManifestHead(ctx, rOut, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	bom, err := sbom.New(sbom.Model{
+		Name:              serverConfig.Name,
+		Version:           serverConfig.Version,
+		License:           serverConfig.License,
+		ServableLicenses:  servableLicenses,
+		Image:             rOut.SetDigest(m.GetDescriptor().Digest.String()).CommonName(),
+		BaseImage:         rSrc.CommonName(),
+		BaseDigest:        baseDigest,
+		Files:             files,
+		ToolVersion:       provenance.ToolVersion(version.GetInfo()),
+		MethodFilePrefix:  constants.MethodPrefix,
+		MetadataDirectory: constants.MetaDirName,
+	})
+	if err != nil {
+		return
+	}
+
+	bomBytes, err := bom.Marshal()
 	if err != nil {
 		return
 	}
 
-	utils.PrintString(os.Stdout, fmt.Sprintf("image %s pushed to registry successfully\n", rOut.CommonName()))
-	err = rc.Close(ctx, rOut)
+	sbomFilePath := filepath.Join(currWorkDir, constants.MetaDirName, sbom.FileName)
+	err = os.WriteFile(sbomFilePath, bomBytes, 0644)
 	if err != nil {
-		err = fmt.Errorf("failed to close ref: %w", err)
+		return
 	}
 
-	err = bo.updateImageInfo(imageRef)
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("sbom written to %s\n", sbomFilePath))
+	if !bo.pushSBOM {
+		return
+	}
+
+	dig, err := sbom.PushReferrer(ctx, rc, rOut, m.GetDescriptor(), bomBytes)
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("export server.yaml failed\n", rOut.CommonName()))
+		return
 	}
+
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("sbom pushed to %s@%s\n", rOut.CommonName(), dig))
 	return
 }
 
-func (bo *BuildOptions) updateImageInfo(imageRef imageref.ImageRef) (err error) {
+// buildParameters records every input of the build after the spec and the flags are merged, the lists and maps
+// are JSON encoded as their values may contain commas
+func (bo *BuildOptions) buildParameters() map[string]string {
+	params := map[string]string{
+		"baseImage":      bo.baseImage,
+		"targetImage":    bo.targetImage,
+		"platforms":      bo.platforms,
+		"deviceType":     bo.deviceType,
+		"skipScript":     strconv.FormatBool(bo.skipScript),
+		"spec":           bo.specFile,
+		"workdir":        bo.workingDir,
+		"user":           bo.user,
+		"stopSignal":     bo.stopSignal,
+		"entrypointMode": bo.entrypointMode,
+		"owner":          bo.owner,
+		"fileMode":       bo.fileMode,
+		"dirMode":        bo.dirMode,
+		"readOnly":       strconv.FormatBool(bo.readOnly),
+		"dereference":    strconv.FormatBool(bo.dereference),
+		"maxLayerSize":   bo.maxLayerSize,
+		"squash":         strconv.FormatBool(bo.squash),
+		"locked":         strconv.FormatBool(bo.locked),
+	}
+
+	for name, value := range map[string]interface{}{
+		"tags":        bo.tags,
+		"env":         bo.env,
+		"labels":      bo.labels,
+		"ports":       bo.ports,
+		"cmd":         bo.cmd,
+		"ignore":      bo.ignore,
+		"removePaths": bo.removePaths,
+		"matrix":      bo.matrix,
+	} {
+		encoded, _ := json.Marshal(value)
+		params[name] = string(encoded)
+	}
+
+	return params
+}
+
+func (bo *BuildOptions) updateImageInfo(imageRef imageref.ImageRef, baseImageInfo *server.BaseImageInfo) (err error) {
 	currWorkDir, err := os.Getwd()
 	if err != nil {
 		return
 	}
 
+	serverConfigFilePath, updatedServerConfigBytes, err := bo.serverFileContent(currWorkDir, imageRef, baseImageInfo)
+	if err != nil {
+		return
+	}
+
+	err = utils.WriteFileIfChanged(serverConfigFilePath, updatedServerConfigBytes, 0666)
+	if err != nil {
+		return
+	}
+
+	err = CopyFile(bo.rootOpts.messages(), serverConfigFilePath, bo.outputServerFilePath)
+	if err != nil {
+		return
+	}
+
+	utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("export server.yaml successffully\n"))
+	return
+}
+
+// serverFileContent renders the server.yaml of the workspace describing the servables and the pushed image
+func (bo *BuildOptions) serverFileContent(currWorkDir string, imageRef imageref.ImageRef, baseImageInfo *server.BaseImageInfo) (serverConfigFilePath string, content []byte, err error) {
 	metaDirPath := filepath.Join(currWorkDir, constants.MetaDirName)
 	_, err = os.Stat(metaDirPath)
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf(".modelmesh not found, current workspace not initialized correctly\n"))
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf(".modelmesh not found, current workspace not initialized correctly\n"))
 		return
 	}
 
-	serverConfigFilePath := filepath.Join(metaDirPath, constants.ServerConfigFile)
+	serverConfigFilePath = filepath.Join(metaDirPath, constants.ServerConfigFile)
 	serverConfigBytes, err := os.ReadFile(serverConfigFilePath)
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("read current server.yaml content failed, %v\n", err))
+		utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("read current server.yaml content failed, %v\n", err))
 		return
 	}
 
@@ -233,16 +1704,24 @@ func (bo *BuildOptions) updateImageInfo(imageRef imageref.ImageRef) (err error)
 		return
 	}
 
+	existingServables := map[string]server.ServableConfig{}
+	for _, servable := range serverConfig.Servables {
+		existingServables[servable.Name] = servable
+	}
+
 	newServables := []server.ServableConfig{}
 	for _, subModelName := range subModelDirs {
-		servableConfig := server.ServableConfig{
-			Name:        subModelName,
-			Description: fmt.Sprintf("infer model %s", subModelName),
+		servableConfig, ok := existingServables[subModelName]
+		if !ok {
+			servableConfig = server.ServableConfig{
+				Name:        subModelName,
+				Description: fmt.Sprintf("infer model %s", subModelName),
+			}
 		}
 
-		methodDetails, _err := GetModelMethods(filepath.Join(currWorkDir, subModelName))
+		methodDetails, _err := GetModelMethods(bo.rootOpts.messages(), filepath.Join(currWorkDir, subModelName))
 		if _err != nil {
-			utils.PrintYellow(os.Stdout, fmt.Sprintf("get methods Docs for model directory [%s] failed\n", subModelName))
+			utils.PrintYellow(bo.rootOpts.messages(), fmt.Sprintf("get methods Docs for model directory [%s] failed\n", subModelName))
 			continue
 		}
 
@@ -257,23 +1736,9 @@ func (bo *BuildOptions) updateImageInfo(imageRef imageref.ImageRef) (err error)
 		Repository: imageRef.Repository,
 		Tag:        imageRef.Tag,
 	}
+	serverConfig.BaseImage = baseImageInfo
 
-	updatedServerConfigBytes, err := yaml.Marshal(serverConfig)
-	if err != nil {
-		return
-	}
-
-	err = os.WriteFile(serverConfigFilePath, updatedServerConfigBytes, 0666)
-	if err != nil {
-		return
-	}
-
-	err = CopyFile(serverConfigFilePath, bo.outputServerFilePath)
-	if err != nil {
-		return
-	}
-
-	utils.PrintString(os.Stdout, fmt.Sprintf("export server.yaml successffully\n"))
+	content, err = yaml.Marshal(serverConfig)
 	return
 }
 
@@ -287,6 +1752,16 @@ func (bo *BuildOptions) checkServingServerFile(currWorkDir string) bool {
 }
 
 func (bo *BuildOptions) renderServingServer(currWorkDir string) (err error) {
+	content, err := bo.servingServerContent(currWorkDir)
+	if err != nil {
+		return
+	}
+
+	return utils.WriteFileIfChanged(filepath.Join(currWorkDir, constants.ServingServerFile), content, 0666)
+}
+
+// servingServerContent renders serving_server.py for the servables of the workspace
+func (bo *BuildOptions) servingServerContent(currWorkDir string) (content []byte, err error) {
 	templateParams, err := bo.getTemplateParams(currWorkDir)
 	if err != nil {
 		return
@@ -323,44 +1798,72 @@ if __name__ == "__main__":
 		return
 	}
 
-	outputFile, err := os.Create(filepath.Join(currWorkDir, constants.ServingServerFile))
+	output := &bytes.Buffer{}
+	err = t.Execute(output, templateParams)
 	if err != nil {
 		return
 	}
 
-	defer outputFile.Close()
-
-	err = t.Execute(outputFile, templateParams)
+	content = output.Bytes()
 	return
 }
 
 func (bo *BuildOptions) createStartScript(currWorkDir string) (scriptPath string, err error) {
+	scriptPath, content, err := bo.startScriptContent(currWorkDir)
+	if err != nil {
+		return
+	}
+
+	err = utils.WriteFileIfChanged(scriptPath, content, 0755)
+	if err != nil {
+		return
+	}
+
+	err = os.Chmod(scriptPath, 0755)
+	return
+}
+
+// startScriptContent renders the entrypoint script starting serving_server.py, with --max-layer-size it first joins
+// the files that were split across layers
+func (bo *BuildOptions) startScriptContent(currWorkDir string) (scriptPath string, content []byte, err error) {
 	const scriptTemplate = `#!/bin/bash
+{{- if .JoinParts }}
+# join the files split across layers by --max-layer-size on the first start
+find "{{ .Workspace }}" -name '*{{ .PartSuffix }}0000' -print0 | while IFS= read -r -d '' first; do
+  file="${first%{{ .PartSuffix }}0000}"
+  cat "$file"{{ .PartSuffix }}[0-9]* > "$file{{ .PartSuffix }}tmp" && chmod "$(stat -c %a "$first")" "$file{{ .PartSuffix }}tmp" &&
+    mv "$file{{ .PartSuffix }}tmp" "$file" && rm -f "$file"{{ .PartSuffix }}[0-9]* || exit 1
+done || exit 1
+{{- end }}
 source /usr/local/Ascend/ascend-toolkit/set_env.sh
 export LD_LIBRARY_PATH=/usr/local/python3.7.5/lib/python3.7/site-packages/mindspore/lib/:${LD_LIBRARY_PATH}
 
 export PROTOCOL_BUFFERS_PYTHON_IMPLEMENTATION=python
-python {{ . }}
+python {{ .ServingServer }}
 `
 	t, err := template.New("tmpl").Parse(scriptTemplate)
 	if err != nil {
 		return
 	}
 
-	scriptPath = filepath.Join(currWorkDir, constants.ServingStartScript)
-	outputFile, err := os.Create(scriptPath)
+	maxSize, err := bo.layerLimit()
 	if err != nil {
 		return
 	}
 
-	defer outputFile.Close()
-
-	err = t.Execute(outputFile, filepath.Join(currWorkDir, constants.ServingServerFile))
+	scriptPath = filepath.Join(currWorkDir, constants.ServingStartScript)
+	output := &bytes.Buffer{}
+	err = t.Execute(output, map[string]interface{}{
+		"JoinParts":     maxSize > 0,
+		"Workspace":     currWorkDir,
+		"PartSuffix":    archive.PartSuffix,
+		"ServingServer": filepath.Join(currWorkDir, constants.ServingServerFile),
+	})
 	if err != nil {
 		return
 	}
 
-	err = os.Chmod(scriptPath, 0755)
+	content = output.Bytes()
 	return
 }
 
@@ -397,7 +1900,7 @@ func (bo *BuildOptions) getSubDirectories(currWorkDir string) (subDirs []string,
 	return
 }
 
-func GetModelMethods(modelDir string) (methods []server.MethodDetail, err error) {
+func GetModelMethods(out io.Writer, modelDir string) (methods []server.MethodDetail, err error) {
 	methods = []server.MethodDetail{}
 
 	entries, err := os.ReadDir(modelDir)
@@ -418,7 +1921,7 @@ func GetModelMethods(modelDir string) (methods []server.MethodDetail, err error)
 
 		content, _err := os.ReadFile(methodFilePath)
 		if _err != nil {
-			utils.PrintYellow(os.Stdout, fmt.Sprintf("read method file [%s] failed\n", methodFilePath))
+			utils.PrintYellow(out, fmt.Sprintf("read method file [%s] failed\n", methodFilePath))
 			continue
 		}
 
@@ -441,29 +1944,22 @@ func RemoveFileExtension(filename string) string {
 	return filename
 }
 
-func CopyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+func CopyFile(out io.Writer, src, dst string) error {
+	content, err := os.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
 
 	dstFileInfo, err := os.Stat(dst)
 	if err == nil && dstFileInfo.IsDir() {
 		dst = filepath.Join(dst, filepath.Base(src))
 	}
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
+	err = utils.WriteFileIfChanged(dst, content, 0666)
 	if err != nil {
 		return err
 	}
 
-	utils.PrintString(os.Stdout, fmt.Sprintf("export server.yaml to %s successffully\n", dst))
+	utils.PrintString(out, fmt.Sprintf("export server.yaml to %s successffully\n", dst))
 	return nil
 }
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"strings"
+	"testing"
+)
+
+func TestImageChangeCmd(t *testing.T) {
+	t.Parallel()
+	base := v1.ImageConfig{Entrypoint: []string{"/entrypoint.sh"}, Cmd: []string{"serve", "--port", "80"}}
+	tests := []struct {
+		name   string
+		mode   string
+		cmd    []string
+		expect string
+	}{
+		{name: "flag default", mode: constants.EntrypointReplace, cmd: nil, expect: "serve --port 80"},
+		{name: "empty flag", mode: constants.EntrypointReplace, cmd: []string{}, expect: "serve --port 80"},
+		{name: "keep", mode: constants.EntrypointKeep, cmd: []string{}, expect: "serve --port 80"},
+		{name: "cmd flag", mode: constants.EntrypointReplace, cmd: []string{"--port", "8080"}, expect: "--port 8080"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			bo := &BuildOptions{entrypointMode: tt.mode, cmd: tt.cmd}
+			config := bo.imageChange("/opt/model", "/opt/model/start.sh", 1, base).ApplyConfig(base)
+			if received := strings.Join(config.Cmd, " "); received != tt.expect {
+				t.Errorf("expected cmd %q, received %q", tt.expect, received)
+			}
+		})
+	}
+}
+
+func TestImageChangePackctlBase(t *testing.T) {
+	t.Parallel()
+	origin := v1.ImageConfig{Entrypoint: []string{"/entrypoint.sh"}, Cmd: []string{"serve"}}
+	packctlBase := v1.ImageConfig{Entrypoint: []string{constants.StartShell, "-c", "/opt/old/start.sh"}, Cmd: []string{"serve"}}
+	tests := []struct {
+		mode       string
+		entrypoint string
+		cmd        string
+	}{
+		{mode: constants.EntrypointKeep, entrypoint: "/entrypoint.sh", cmd: "serve"},
+		{mode: constants.EntrypointChain, entrypoint: "/entrypoint.sh", cmd: constants.StartShell + " -c /opt/model/start.sh"},
+		{mode: constants.EntrypointReplace, entrypoint: constants.StartShell + " -c /opt/model/start.sh", cmd: "serve"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Parallel()
+			bo := &BuildOptions{entrypointMode: tt.mode}
+			config := bo.imageChange("/opt/model", "/opt/model/start.sh", 1, origin).ApplyConfig(packctlBase)
+			if received := strings.Join(config.Entrypoint, " "); received != tt.entrypoint {
+				t.Errorf("expected entrypoint %q, received %q", tt.entrypoint, received)
+			}
+			if received := strings.Join(config.Cmd, " "); received != tt.cmd {
+				t.Errorf("expected cmd %q, received %q", tt.cmd, received)
+			}
+		})
+	}
+}
+
+func TestValidateJoinParts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		bo     BuildOptions
+		expect bool
+	}{
+		{name: "defaults", bo: BuildOptions{entrypointMode: constants.EntrypointReplace}, expect: true},
+		{name: "keep", bo: BuildOptions{entrypointMode: constants.EntrypointKeep}},
+		{name: "read-only", bo: BuildOptions{entrypointMode: constants.EntrypointReplace, readOnly: true}},
+		{name: "writable dir-mode", bo: BuildOptions{entrypointMode: constants.EntrypointChain, dirMode: "0750"}, expect: true},
+		{name: "dir-mode without write", bo: BuildOptions{entrypointMode: constants.EntrypointReplace, dirMode: "0555"}},
+		{name: "owner is user", bo: BuildOptions{entrypointMode: constants.EntrypointReplace, owner: "1000:1000", user: "1000:1000"}, expect: true},
+		{name: "root user", bo: BuildOptions{entrypointMode: constants.EntrypointReplace, owner: "1000", user: "root"}, expect: true},
+		{name: "other user", bo: BuildOptions{entrypointMode: constants.EntrypointReplace, owner: "1000", user: "1001"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.bo.validateJoinParts()
+			if (err == nil) != tt.expect {
+				t.Errorf("expected valid %t, received %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestBuildParameters(t *testing.T) {
+	t.Parallel()
+	bo := &BuildOptions{
+		tags:         []string{"latest", "{{.Version | replace \".\" \",\"}}"},
+		env:          []string{"A=b,c"},
+		labels:       map[string]string{"org.example.team": "vision"},
+		matrix:       []string{"deviceType=GPU,baseImage=registry.example.org/base:gpu"},
+		maxLayerSize: "2GiB",
+		squash:       true,
+	}
+
+	params := bo.buildParameters()
+	for name, expect := range map[string]string{
+		"tags":         `["latest","{{.Version | replace \".\" \",\"}}"]`,
+		"env":          `["A=b,c"]`,
+		"labels":       `{"org.example.team":"vision"}`,
+		"matrix":       `["deviceType=GPU,baseImage=registry.example.org/base:gpu"]`,
+		"cmd":          "null",
+		"maxLayerSize": "2GiB",
+		"squash":       "true",
+		"readOnly":     "false",
+	} {
+		if received, ok := params[name]; !ok || received != expect {
+			t.Errorf("expected parameter %s %s, received %q", name, expect, received)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/strparse"
+	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// deviceProfileFiles are generated per device type, matrix builds put them into a layer above the shared model layer
+var deviceProfileFiles = []string{constants.ServingServerFile, constants.ServingStartScript}
+
+// matrixEntry is a device type built by a matrix build
+type matrixEntry struct {
+	deviceType string
+	baseImage  string
+	platform   string
+}
+
+// BuildReport is the combined result of a matrix build
+type BuildReport struct {
+	Images []BuildResult `json:"images"`
+}
+
+func validateDeviceType(out io.Writer, deviceType string) error {
+	switch strings.ToLower(deviceType) {
+	default:
+		return fmt.Errorf("unknown deviceType [%s]", deviceType)
+	case "cpu":
+		utils.PrintWarning(out, fmt.Sprintf("deviceType is CPU now\n"))
+	case "ascend", "gpu":
+	}
+
+	return nil
+}
+
+// parseMatrix parses --matrix values such as deviceType=GPU,baseImage=registry.example.org/base:gpu,platform=linux/amd64
+func parseMatrix(out io.Writer, values []string, defaultPlatform string) (entries []matrixEntry, err error) {
+	seen := map[string]bool{}
+	for _, value := range values {
+		kvs, err := strparse.SplitCSKV(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse matrix %s: %w", value, err)
+		}
+
+		entry := matrixEntry{platform: defaultPlatform}
+		for key, val := range kvs {
+			switch key {
+			case "deviceType":
+				entry.deviceType = val
+			case "baseImage":
+				entry.baseImage = val
+			case "platform":
+				entry.platform = val
+			default:
+				return nil, fmt.Errorf("unknown key %s in matrix %s, support: deviceType, baseImage, platform", key, value)
+			}
+		}
+
+		if entry.baseImage == "" {
+			return nil, fmt.Errorf("base image of matrix %s cannot be empty", value)
+		}
+
+		err = validateDeviceType(out, entry.deviceType)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[strings.ToLower(entry.deviceType)] {
+			return nil, fmt.Errorf("deviceType %s is listed twice in the matrix", entry.deviceType)
+		}
+		seen[strings.ToLower(entry.deviceType)] = true
+		entries = append(entries, entry)
+	}
+
+	return
+}
+
+// buildMatrix builds the workspace once per device type, the model files are packed into layers shared by all images
+func (bo *BuildOptions) buildMatrix(ctx context.Context, currWorkDir string, startedOn time.Time) (err error) {
+	workspaceSize, err := getDirectorySize(currWorkDir)
+	if err != nil {
+		return
+	}
+
+	model, err := bo.packLayers(ctx, currWorkDir, "pack workspace", "packctl build "+bo.targetImage, workspaceSize,
+		archive.TarExclude(deviceProfileFiles...))
+	if err != nil {
+		return
+	}
+
+	report := BuildReport{}
+	for i, entry := range bo.matrixEntries {
+		entryOpts := *bo
+		entryOpts.deviceType = entry.deviceType
+		entryOpts.baseImage = entry.baseImage
+		entryOpts.platforms = entry.platform
+		entryOpts.matrixDeviceType = entry.deviceType
+
+		utils.PrintString(bo.rootOpts.messages(), fmt.Sprintf("building deviceType %s on %s\n", entry.deviceType, entry.baseImage))
+		result, err := entryOpts.buildImage(ctx, currWorkDir, startedOn, model)
+		if err != nil {
+			return fmt.Errorf("failed to build deviceType %s: %w", entry.deviceType, err)
+		}
+
+		report.Images = append(report.Images, result)
+		// server.yaml describes a single image, the first device type of the matrix
+		if i == 0 {
+			err = bo.updateImageInfo(result.imageRef, &server.BaseImageInfo{Image: result.BaseImage, Digest: result.BaseDigest})
+			if err != nil {
+				utils.PrintWarning(bo.rootOpts.messages(), fmt.Sprintf("export server.yaml failed, %v\n", err))
+			}
+		}
+	}
+
+	return bo.rootOpts.printResult(os.Stdout, report, report.print)
+}
+
+func (br BuildReport) print(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DEVICE TYPE\tPLATFORM\tIMAGE\tDIGEST\tBASE IMAGE\n")
+	for _, result := range br.Images {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s@%s\n", result.DeviceType, result.Platform, result.Image, result.Digest, result.BaseImage, result.BaseDigest)
+		for _, tag := range result.Tags {
+			fmt.Fprintf(tw, "\t\t%s\t\t\n", tag)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// tagWithSuffix appends suffix to the tag of image, image may also be a plain tag
+func tagWithSuffix(image, suffix string) string {
+	if !strings.ContainsAny(image, "/:") || strings.Contains(path.Base(image), ":") {
+		return image + suffix
+	}
+
+	return image + ":latest" + suffix
+}
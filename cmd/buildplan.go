@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/modelimage"
+	"github.com/edgewize-io/image-packaging-tool/pkg/progress"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/regclient/regclient"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// BuildPlanReport lists what a dry run build would push
+type BuildPlanReport struct {
+	Plans []BuildPlan `json:"plans"`
+}
+
+// BuildPlan describes the image a build would push
+type BuildPlan struct {
+	DeviceType     string            `json:"deviceType"`
+	Platform       string            `json:"platform"`
+	BaseImage      string            `json:"baseImage"`
+	BaseDigest     string            `json:"baseDigest"`
+	Image          string            `json:"image"`
+	Tags           []string          `json:"tags,omitempty"`
+	Layers         []PlanLayer       `json:"layers"`
+	RemovedPaths   []RemovedPath     `json:"removedPaths,omitempty"`
+	SquashedLayers int               `json:"squashedLayers,omitempty"`
+	SquashedBase   int               `json:"squashedBase,omitempty"`
+	GeneratedFiles []GeneratedFile   `json:"generatedFiles"`
+	BaseEntrypoint []string          `json:"baseEntrypoint,omitempty"`
+	BaseCmd        []string          `json:"baseCmd,omitempty"`
+	EntrypointMode string            `json:"entrypointMode"`
+	Shell          string            `json:"shell,omitempty"`
+	Entrypoint     []string          `json:"entrypoint,omitempty"`
+	Cmd            []string          `json:"cmd,omitempty"`
+	Env            []string          `json:"env,omitempty"`
+	ExposedPorts   []string          `json:"exposedPorts,omitempty"`
+	WorkingDir     string            `json:"workingDir,omitempty"`
+	User           string            `json:"user,omitempty"`
+	StopSignal     string            `json:"stopSignal,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	ServerFile     string            `json:"serverFile,omitempty"`
+	Owner          string            `json:"owner,omitempty"`
+}
+
+// PlanLayer is a layer a build would add, file names are relative to the workspace
+type PlanLayer struct {
+	Name  string     `json:"name"`
+	Size  int64      `json:"size"`
+	Files []PlanFile `json:"files"`
+}
+
+// PlanFile is a file of a layer, Mode is empty for generated files that do not exist yet
+type PlanFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// RemovedPath is a base image path the build hides with a whiteout, Files are the entries it hides
+type RemovedPath struct {
+	Path  string     `json:"path"`
+	Size  int64      `json:"size"`
+	Files []PlanFile `json:"files"`
+}
+
+// GeneratedFile is a file a build would write into the workspace
+type GeneratedFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// planBuild prints the plan of every image the build would push, neither the workspace nor the registry are changed
+func (bo *BuildOptions) planBuild(ctx context.Context, currWorkDir string, startedOn time.Time) (err error) {
+	report := BuildPlanReport{}
+	if len(bo.matrixEntries) == 0 {
+		plan, err := bo.planImage(ctx, currWorkDir, startedOn, false, true)
+		if err != nil {
+			return err
+		}
+		report.Plans = append(report.Plans, plan)
+	}
+
+	for i, entry := range bo.matrixEntries {
+		entryOpts := *bo
+		entryOpts.deviceType = entry.deviceType
+		entryOpts.baseImage = entry.baseImage
+		entryOpts.platforms = entry.platform
+		entryOpts.matrixDeviceType = entry.deviceType
+
+		plan, err := entryOpts.planImage(ctx, currWorkDir, startedOn, true, i == 0)
+		if err != nil {
+			return fmt.Errorf("failed to plan deviceType %s: %w", entry.deviceType, err)
+		}
+		report.Plans = append(report.Plans, plan)
+	}
+
+	return bo.rootOpts.printResult(os.Stdout, report, report.print)
+}
+
+// planImage resolves the base image and collects the layers, generated files and config the build would push.
+// Matrix builds split the generated files into a layer above the model layer, withServerFile adds the server.yaml
+func (bo *BuildOptions) planImage(ctx context.Context, currWorkDir string, startedOn time.Time, matrix, withServerFile bool) (plan BuildPlan, err error) {
+	err = bo.renderTags(currWorkDir, startedOn)
+	if err != nil {
+		return
+	}
+
+	generated := map[string][]byte{}
+	if !bo.skipScript {
+		generated[constants.ServingServerFile], err = bo.servingServerContent(currWorkDir)
+		if err != nil {
+			return
+		}
+	} else if !bo.checkServingServerFile(currWorkDir) {
+		err = fmt.Errorf("must provide serving_server.py yourself")
+		return
+	}
+
+	startScriptPath, startScript, err := bo.startScriptContent(currWorkDir)
+	if err != nil {
+		return
+	}
+	generated[constants.ServingStartScript] = startScript
+
+	pf, rSrc, rTgt, imageRef, rTags, err := bo.resolveRefs()
+	if err != nil {
+		return
+	}
+
+	rc := bo.rootOpts.newRegClient()
+	defer rc.Close(ctx, rSrc)
+
+	baseImage, err := modelimage.Get(ctx, rc, rSrc, pf)
+	if err != nil {
+		err = fmt.Errorf("failed to read base image %s: %w", rSrc.CommonName(), err)
+		return
+	}
+
+	baseImage.Limiter, err = bo.rootOpts.rateLimiter(rSrc.Registry, bo.limitRate)
+	if err != nil {
+		return
+	}
+
+	// in locked mode the lock file is only read
+	if bo.locked {
+		err = bo.pinBaseImage(currWorkDir, baseImage.Digest)
+		if err != nil {
+			return
+		}
+	}
+
+	if matrix {
+		var model, profile []PlanLayer
+		model, err = bo.planLayers(ctx, currWorkDir, "model", nil, false, archive.TarExclude(deviceProfileFiles...))
+		if err != nil {
+			return
+		}
+		profile, err = bo.planLayers(ctx, currWorkDir, "device profile", generated, true, archive.TarInclude(deviceProfileFiles...))
+		if err != nil {
+			return
+		}
+		plan.Layers = append(model, profile...)
+	} else {
+		plan.Layers, err = bo.planLayers(ctx, currWorkDir, "workspace", generated, false)
+		if err != nil {
+			return
+		}
+	}
+
+	baseLayers := 0
+	if baseImage.IsPackctlImage() {
+		baseLayers = baseImage.PackctlLayerCount()
+	}
+
+	// --squash replaces the layers of the build and the packctl layers of the base image by a single one
+	packctlLayers := baseLayers + len(plan.Layers)
+	if bo.squash && packctlLayers > 1 {
+		plan.SquashedLayers, plan.SquashedBase = packctlLayers, baseLayers
+		packctlLayers = 1
+	}
+
+	plan.Shell, err = bo.checkStartShell(ctx, rc, currWorkDir, baseImage)
+	if err != nil {
+		return
+	}
+
+	plan.RemovedPaths, err = bo.planRemovedPaths(ctx, rc, baseImage)
+	if err != nil {
+		return
+	}
+
+	baseConfig := bo.startConfig(ctx, rc, pf, baseImage)
+	config := bo.imageChange(currWorkDir, startScriptPath, packctlLayers, baseConfig).ApplyConfig(baseConfig)
+	plan.BaseEntrypoint = baseConfig.Entrypoint
+	plan.BaseCmd = baseConfig.Cmd
+	plan.EntrypointMode = bo.entrypointMode
+	plan.Owner = bo.owner
+	plan.DeviceType = bo.deviceType
+	plan.Platform = bo.platforms
+	plan.BaseImage = bo.baseImage
+	plan.BaseDigest = baseImage.Digest.String()
+	plan.Image = rTgt.CommonName()
+	plan.Entrypoint = config.Entrypoint
+	plan.Cmd = config.Cmd
+	plan.Env = config.Env
+	plan.WorkingDir = config.WorkingDir
+	plan.User = config.User
+	plan.StopSignal = config.StopSignal
+	for port := range config.ExposedPorts {
+		plan.ExposedPorts = append(plan.ExposedPorts, port)
+	}
+	sort.Strings(plan.ExposedPorts)
+	plan.Labels = config.Labels
+	for _, rTag := range rTags {
+		plan.Tags = append(plan.Tags, rTag.CommonName())
+	}
+
+	for _, name := range []string{constants.ServingServerFile, constants.ServingStartScript} {
+		if content, ok := generated[name]; ok {
+			plan.GeneratedFiles = append(plan.GeneratedFiles, GeneratedFile{Name: name, Content: string(content)})
+		}
+	}
+
+	if withServerFile {
+		var content []byte
+		_, content, err = bo.serverFileContent(currWorkDir, imageRef, &server.BaseImageInfo{Image: bo.baseImage, Digest: baseImage.Digest.String()})
+		if err != nil {
+			return
+		}
+		plan.ServerFile = string(content)
+	}
+
+	return
+}
+
+// planRemovedPaths lists the entries of the base image each --remove-path hides
+func (bo *BuildOptions) planRemovedPaths(ctx context.Context, rc *regclient.RegClient, baseImage *modelimage.Image) (removed []RemovedPath, err error) {
+	if len(bo.removePaths) == 0 {
+		return
+	}
+
+	task := bo.progress.Start("list base image paths to remove", 0)
+	entries, err := baseImage.ListBelow(ctx, rc, bo.removePaths, task.Writer())
+	task.Done()
+	if err != nil {
+		err = fmt.Errorf("failed to list base image %s: %w", bo.baseImage, err)
+		return
+	}
+
+	for _, removePath := range bo.removePaths {
+		removedPath := RemovedPath{Path: removePath, Files: []PlanFile{}}
+		for _, hdr := range entries[removePath] {
+			removedPath.Files = append(removedPath.Files, PlanFile{Name: hdr.Name, Size: hdr.Size, Mode: hdr.FileInfo().Mode().String()})
+			removedPath.Size += hdr.Size
+		}
+		removed = append(removed, removedPath)
+	}
+
+	return
+}
+
+// planLayers lists the files packLayers would pack with opts, generated holds the files the build writes into the
+// layers before packing them, they are listed with their new size. Unless single is set the files are split like
+// packLayers does with --max-layer-size, generated files that do not exist yet are planned last
+func (bo *BuildOptions) planLayers(ctx context.Context, currWorkDir, name string, generated map[string][]byte, single bool, opts ...archive.TarOpts) (layers []PlanLayer, err error) {
+	entries, err := archive.List(ctx, currWorkDir, append(bo.workspaceTarOpts(), opts...)...)
+	if err != nil {
+		return
+	}
+
+	modes := map[string]string{}
+	for i, entry := range entries {
+		modes[entry.Name] = entry.Mode.String()
+		if content, ok := generated[entry.Name]; ok {
+			entries[i].Size = int64(len(content))
+		}
+	}
+
+	generatedNames := []string{}
+	for fileName := range generated {
+		if _, ok := modes[fileName]; !ok {
+			generatedNames = append(generatedNames, fileName)
+		}
+	}
+	sort.Strings(generatedNames)
+	for _, fileName := range generatedNames {
+		entries = append(entries, archive.ListEntry{Name: fileName, Size: int64(len(generated[fileName]))})
+	}
+
+	maxSize, err := bo.layerLimit()
+	if err != nil {
+		return
+	}
+
+	// a nil LayerFiles stands for all files
+	splits := []archive.LayerFiles{nil}
+	if maxSize > 0 && !single {
+		splits = archive.SplitLayers(entries, maxSize-maxSize/64)
+	}
+
+	for i, files := range splits {
+		layer := PlanLayer{Name: name, Files: []PlanFile{}}
+		if len(splits) > 1 {
+			layer.Name = fmt.Sprintf("%s %d/%d", name, i+1, len(splits))
+		}
+
+		for _, entry := range entries {
+			if entry.Mode.IsDir() {
+				continue
+			}
+
+			parts, ok := files[entry.Name]
+			if files != nil && !ok {
+				continue
+			}
+
+			if len(parts) == 0 {
+				layer.Files = append(layer.Files, PlanFile{Name: entry.Name, Size: entry.Size, Mode: modes[entry.Name]})
+			}
+			for _, part := range parts {
+				layer.Files = append(layer.Files, PlanFile{Name: archive.PartName(entry.Name, part.Index), Size: part.Size, Mode: modes[entry.Name]})
+			}
+		}
+
+		sort.Slice(layer.Files, func(i, j int) bool { return layer.Files[i].Name < layer.Files[j].Name })
+		for _, file := range layer.Files {
+			layer.Size += file.Size
+		}
+		layers = append(layers, layer)
+	}
+
+	return
+}
+
+func (br BuildPlanReport) print(out io.Writer) error {
+	for i, plan := range br.Plans {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Image:\t%s (dry run, nothing pushed)\n", plan.Image)
+		for _, tag := range plan.Tags {
+			fmt.Fprintf(tw, "Tag:\t%s\n", tag)
+		}
+		fmt.Fprintf(tw, "Device type:\t%s\n", plan.DeviceType)
+		fmt.Fprintf(tw, "Platform:\t%s\n", plan.Platform)
+		fmt.Fprintf(tw, "Base image:\t%s@%s\n", plan.BaseImage, plan.BaseDigest)
+		fmt.Fprintf(tw, "Base entrypoint:\t%s\n", formatCommand(plan.BaseEntrypoint))
+		fmt.Fprintf(tw, "Base cmd:\t%s\n", formatCommand(plan.BaseCmd))
+		fmt.Fprintf(tw, "Entrypoint mode:\t%s\n", plan.EntrypointMode)
+		if plan.Shell != "" {
+			fmt.Fprintf(tw, "Shell:\t%s\n", plan.Shell)
+		}
+		fmt.Fprintf(tw, "Entrypoint:\t%s\n", formatCommand(plan.Entrypoint))
+		fmt.Fprintf(tw, "Cmd:\t%s\n", formatCommand(plan.Cmd))
+		if plan.WorkingDir != "" {
+			fmt.Fprintf(tw, "Working dir:\t%s\n", plan.WorkingDir)
+		}
+		if plan.Owner != "" {
+			fmt.Fprintf(tw, "File owner:\t%s\n", plan.Owner)
+		}
+		if plan.User != "" {
+			fmt.Fprintf(tw, "User:\t%s\n", plan.User)
+		}
+		if plan.StopSignal != "" {
+			fmt.Fprintf(tw, "Stop signal:\t%s\n", plan.StopSignal)
+		}
+		if len(plan.ExposedPorts) > 0 {
+			fmt.Fprintf(tw, "Exposed ports:\t%s\n", strings.Join(plan.ExposedPorts, " "))
+		}
+		if len(plan.Env) > 0 {
+			fmt.Fprintf(tw, "Env:\n")
+			for _, env := range plan.Env {
+				fmt.Fprintf(tw, "  %s\n", env)
+			}
+		}
+		if len(plan.Labels) > 0 {
+			fmt.Fprintf(tw, "Labels:\n")
+			labelNames := []string{}
+			for name := range plan.Labels {
+				labelNames = append(labelNames, name)
+			}
+			sort.Strings(labelNames)
+			for _, name := range labelNames {
+				fmt.Fprintf(tw, "  %s=%s\n", name, plan.Labels[name])
+			}
+		}
+
+		for j, layer := range plan.Layers {
+			fmt.Fprintf(tw, "Layer %d (%s):\t%d files, %s\n", j+1, layer.Name, len(layer.Files), progress.FormatBytes(layer.Size))
+			for _, file := range layer.Files {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\n", file.Mode, progress.FormatBytes(file.Size), file.Name)
+			}
+		}
+		if plan.SquashedLayers > 0 {
+			fmt.Fprintf(tw, "Squash:\t%d layers into one, %d of them from the base image\n", plan.SquashedLayers, plan.SquashedBase)
+		}
+		for _, removed := range plan.RemovedPaths {
+			if len(removed.Files) == 0 {
+				fmt.Fprintf(tw, "Remove path %s:\tnot in the base image\n", removed.Path)
+				continue
+			}
+			fmt.Fprintf(tw, "Remove path %s:\t%d files hidden, %s\n", removed.Path, len(removed.Files), progress.FormatBytes(removed.Size))
+			for _, file := range removed.Files {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\n", file.Mode, progress.FormatBytes(file.Size), file.Name)
+			}
+		}
+
+		err := tw.Flush()
+		if err != nil {
+			return err
+		}
+
+		for _, file := range plan.GeneratedFiles {
+			fmt.Fprintf(out, "--- %s\n%s\n", file.Name, strings.TrimSpace(file.Content))
+		}
+		if plan.ServerFile != "" {
+			fmt.Fprintf(out, "--- %s\n%s\n", constants.ServerConfigFile, strings.TrimSpace(plan.ServerFile))
+		}
+	}
+
+	return nil
+}
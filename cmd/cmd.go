@@ -1,8 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	localConfig "github.com/edgewize-io/image-packaging-tool/pkg/configuration"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
 	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/strparse"
 	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/version"
 	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
@@ -11,7 +13,9 @@ import (
 	"github.com/regclient/regclient/scheme/reg"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
 	"os"
+	"text/template"
 	"time"
 )
 
@@ -24,6 +28,7 @@ type RootOptions struct {
 	format    string // for Go template formatting of various commands
 	hosts     []string
 	userAgent string
+	stdout    bool // the command writes its output to stdout, e.g. render --stdout
 }
 
 func NewImagePackagingCommand() *cobra.Command {
@@ -38,11 +43,20 @@ func NewImagePackagingCommand() *cobra.Command {
 	packCtlCmd.AddCommand(NewCmdBuild(rootOptions))
 	packCtlCmd.AddCommand(NewCmdLogin(rootOptions))
 	packCtlCmd.AddCommand(NewCmdLogout(rootOptions))
+	packCtlCmd.AddCommand(NewCmdExtract(rootOptions))
+	packCtlCmd.AddCommand(NewCmdDiff(rootOptions))
+	packCtlCmd.AddCommand(NewCmdInspect(rootOptions))
+	packCtlCmd.AddCommand(NewCmdSign(rootOptions))
+	packCtlCmd.AddCommand(NewCmdVerifyImage(rootOptions))
+	packCtlCmd.AddCommand(NewCmdGenerateKeyPair())
+	packCtlCmd.AddCommand(NewCmdUpdateBase(rootOptions))
+	packCtlCmd.AddCommand(NewCmdRender(rootOptions))
 
 	packCtlCmd.PersistentFlags().StringVarP(&rootOptions.verbosity, "verbosity", "v", logrus.WarnLevel.String(), "Log level (debug, info, warn, error, fatal, panic)")
 	packCtlCmd.PersistentFlags().StringArrayVar(&rootOptions.logopts, "logopt", []string{}, "Log options")
 	packCtlCmd.PersistentFlags().StringArrayVar(&rootOptions.hosts, "host", []string{}, "Registry hosts to add (reg=registry,user=username,pass=password,tls=enabled)")
 	packCtlCmd.PersistentFlags().StringVarP(&rootOptions.userAgent, "user-agent", "", "", "Override user agent")
+	packCtlCmd.PersistentFlags().StringVar(&rootOptions.format, "format", "", "Output format of reports: json, or a Go template")
 
 	return packCtlCmd
 }
@@ -50,7 +64,7 @@ func NewImagePackagingCommand() *cobra.Command {
 func (ro *RootOptions) newRegClient() *regclient.RegClient {
 	conf, err := localConfig.ConfigLoadDefault()
 	if err != nil {
-		utils.PrintWarning(os.Stdout, fmt.Sprintf("failed to load default\n"))
+		utils.PrintWarning(ro.messages(), fmt.Sprintf("failed to load default\n"))
 		if conf == nil {
 			conf = localConfig.ConfigNew()
 		}
@@ -67,16 +81,7 @@ func (ro *RootOptions) newRegClient() *regclient.RegClient {
 		regclient.WithLog(regLog),
 		regclient.WithRegOpts(reg.WithCache(time.Minute*5, 500)),
 	}
-	if ro.userAgent != "" {
-		rcOpts = append(rcOpts, regclient.WithUserAgent(ro.userAgent))
-	} else {
-		info := version.GetInfo()
-		if info.VCSTag != "" {
-			rcOpts = append(rcOpts, regclient.WithUserAgent(UserAgent+" ("+info.VCSTag+")"))
-		} else {
-			rcOpts = append(rcOpts, regclient.WithUserAgent(UserAgent+" ("+info.VCSRef+")"))
-		}
-	}
+	rcOpts = append(rcOpts, regclient.WithUserAgent(ro.userAgentString()))
 	if conf.BlobLimit != 0 {
 		rcOpts = append(rcOpts, regclient.WithRegOpts(reg.WithBlobLimit(conf.BlobLimit)))
 	}
@@ -87,6 +92,16 @@ func (ro *RootOptions) newRegClient() *regclient.RegClient {
 		rcOpts = append(rcOpts, regclient.WithDockerCerts())
 	}
 
+	rcHosts := ro.configHosts(conf)
+	if len(rcHosts) > 0 {
+		rcOpts = append(rcOpts, regclient.WithConfigHost(rcHosts...))
+	}
+
+	return regclient.New(rcOpts...)
+}
+
+// configHosts returns the registry hosts of the config file followed by the --host flags
+func (ro *RootOptions) configHosts(conf *localConfig.Config) []regConfig.Host {
 	rcHosts := []regConfig.Host{}
 	for name, host := range conf.Hosts {
 		host.Name = name
@@ -95,7 +110,7 @@ func (ro *RootOptions) newRegClient() *regclient.RegClient {
 	for _, h := range ro.hosts {
 		hKV, err := strparse.SplitCSKV(h)
 		if err != nil {
-			utils.PrintWarning(os.Stdout, fmt.Sprintf("unable to parse host string\n"))
+			utils.PrintWarning(ro.messages(), fmt.Sprintf("unable to parse host string\n"))
 		}
 		host := regConfig.Host{
 			Name: hKV["reg"],
@@ -106,16 +121,126 @@ func (ro *RootOptions) newRegClient() *regclient.RegClient {
 			var hostTLS regConfig.TLSConf
 			err := hostTLS.UnmarshalText([]byte(hKV["tls"]))
 			if err != nil {
-				utils.PrintWarning(os.Stdout, fmt.Sprintf("unable to parse tls setting\n"))
+				utils.PrintWarning(ro.messages(), fmt.Sprintf("unable to parse tls setting\n"))
 			} else {
 				host.TLS = hostTLS
 			}
 		}
 		rcHosts = append(rcHosts, host)
 	}
-	if len(rcHosts) > 0 {
-		rcOpts = append(rcOpts, regclient.WithConfigHost(rcHosts...))
+
+	return rcHosts
+}
+
+// hostConfig merges the docker credentials, config file and --host flags for a registry the same way newRegClient does
+func (ro *RootOptions) hostConfig(registry string) *regConfig.Host {
+	conf, err := localConfig.ConfigLoadDefault()
+	if err != nil && conf == nil {
+		conf = localConfig.ConfigNew()
 	}
 
-	return regclient.New(rcOpts...)
+	host := regConfig.HostNewName(registry)
+	hosts := []regConfig.Host{}
+	if conf.IncDockerCred == nil || *conf.IncDockerCred {
+		dockerHosts, err := regConfig.DockerLoad()
+		if err == nil {
+			hosts = append(hosts, dockerHosts...)
+		}
+	}
+
+	hosts = append(hosts, ro.configHosts(conf)...)
+	for _, h := range hosts {
+		if regConfig.HostNewName(h.Name).Name != host.Name {
+			continue
+		}
+		if host.Name == regConfig.DockerRegistry {
+			h.Name = regConfig.DockerRegistry
+			if h.Hostname == "" || h.Hostname == regConfig.DockerRegistry || h.Hostname == regConfig.DockerRegistryAuth {
+				h.Hostname = regConfig.DockerRegistryDNS
+			}
+		}
+		_ = host.Merge(h, nil)
+	}
+
+	return host
+}
+
+// rateLimiter throttles blob transfers with registry to limitRate, or to the limitRate of the
+// registry in the config file when limitRate is empty, it returns nil when neither is set
+func (ro *RootOptions) rateLimiter(registry, limitRate string) (*ratelimit.Limiter, error) {
+	if limitRate == "" {
+		conf, err := localConfig.ConfigLoadDefault()
+		if err != nil && conf == nil {
+			conf = localConfig.ConfigNew()
+		}
+
+		name := regConfig.HostNewName(registry).Name
+		for host, rate := range conf.LimitRate {
+			if regConfig.HostNewName(host).Name == name {
+				limitRate = rate
+				break
+			}
+		}
+	}
+
+	if limitRate == "" {
+		return nil, nil
+	}
+
+	rate, err := utils.ParseSize(limitRate)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("invalid limit rate %s for registry %s", limitRate, registry)
+	}
+
+	return ratelimit.New(rate), nil
+}
+
+// userAgentString returns the user agent sent to registries
+func (ro *RootOptions) userAgentString() string {
+	if ro.userAgent != "" {
+		return ro.userAgent
+	}
+
+	info := version.GetInfo()
+	if info.VCSTag != "" {
+		return UserAgent + " (" + info.VCSTag + ")"
+	}
+
+	return UserAgent + " (" + info.VCSRef + ")"
+}
+
+// printResult writes data as JSON or through the Go template given by --format,
+// and falls back to printText for the human readable output
+func (ro *RootOptions) printResult(out io.Writer, data interface{}, printText func(io.Writer) error) error {
+	switch ro.format {
+	case "":
+		return printText(out)
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	default:
+		t, err := template.New("format").Parse(ro.format)
+		if err != nil {
+			return fmt.Errorf("failed to parse format: %w", err)
+		}
+
+		err = t.Execute(out, data)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out)
+		return err
+	}
+}
+
+// messages returns the writer for the progress and status messages of a command, stderr when --format writes a
+// report to stdout or the command prints its output there so the output can be parsed
+func (ro *RootOptions) messages() io.Writer {
+	if ro.format != "" || ro.stdout {
+		return os.Stderr
+	}
+
+	return os.Stdout
 }
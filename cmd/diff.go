@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/modelimage"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+type DiffOptions struct {
+	rootOpts  *RootOptions
+	imageA    string
+	imageB    string
+	platform  string
+	limitRate string
+}
+
+// DiffEntry is a single difference between two model images
+type DiffEntry struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// DiffReport lists the differences between two model images
+type DiffReport struct {
+	ImageA  string      `json:"imageA"`
+	ImageB  string      `json:"imageB"`
+	Entries []DiffEntry `json:"entries"`
+}
+
+// modelSummary holds the parts of a model image that are compared
+type modelSummary struct {
+	baseName   string
+	baseDigest string
+	baseLayers []string
+	entrypoint []string
+	cmd        []string
+	servables  map[string]server.ServableConfig
+	files      map[string]string
+}
+
+func NewCmdDiff(rootOptions *RootOptions) *cobra.Command {
+	diffOptions := &DiffOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "diff <imageA> <imageB>",
+		Short: "compare two model images",
+		Long: `Compare the base images, entrypoints, servables, methods and model files
+of two images built by packctl.`,
+		Example: `
+# show what changed between two model versions
+packctl diff registry.example.org/models/resnet:v1 registry.example.org/models/resnet:v2
+
+# machine readable output
+packctl diff --format json registry.example.org/models/resnet:v1 registry.example.org/models/resnet:v2`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			diffOptions.imageA = args[0]
+			diffOptions.imageB = args[1]
+			return diffOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&diffOptions.platform, "platform", "linux/amd64", "image platform to compare, default: linux/amd64")
+	command.Flags().StringVar(&diffOptions.limitRate, "limit-rate", "", "limit layer download throughput per second, e.g. 2MiB, default: limitRate of the registry in the config file")
+
+	return command
+}
+
+func (do *DiffOptions) run(ctx context.Context) (err error) {
+	pf, err := platform.Parse(do.platform)
+	if err != nil {
+		err = fmt.Errorf("failed to parse platform %s: %v", do.platform, err)
+		return
+	}
+
+	rc := do.rootOpts.newRegClient()
+
+	summaryA, err := do.summarizeModelImage(ctx, rc, do.imageA, pf)
+	if err != nil {
+		return
+	}
+
+	summaryB, err := do.summarizeModelImage(ctx, rc, do.imageB, pf)
+	if err != nil {
+		return
+	}
+
+	report := DiffReport{
+		ImageA:  do.imageA,
+		ImageB:  do.imageB,
+		Entries: diffModelSummaries(summaryA, summaryB),
+	}
+
+	return do.rootOpts.printResult(os.Stdout, report, report.print)
+}
+
+func (dr DiffReport) print(out io.Writer) error {
+	if len(dr.Entries) == 0 {
+		_, err := fmt.Fprintf(out, "no differences between %s and %s\n", dr.ImageA, dr.ImageB)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHANGE\tKIND\tNAME\tOLD\tNEW\n")
+	for _, entry := range dr.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Change, entry.Kind, entry.Name, entry.Old, entry.New)
+	}
+
+	return tw.Flush()
+}
+
+func (do *DiffOptions) summarizeModelImage(ctx context.Context, rc *regclient.RegClient, image string, pf platform.Platform) (summary *modelSummary, err error) {
+	r, err := ref.New(image)
+	if err != nil {
+		return
+	}
+
+	defer rc.Close(ctx, r)
+
+	img, err := modelimage.Get(ctx, rc, r, pf)
+	if err != nil {
+		return
+	}
+
+	img.Limiter, err = do.rootOpts.rateLimiter(r.Registry, do.limitRate)
+	if err != nil {
+		return
+	}
+
+	config := img.Config.GetConfig().Config
+	summary = &modelSummary{
+		baseName:   img.BaseName(),
+		baseDigest: img.BaseDigest(),
+		baseLayers: []string{},
+		entrypoint: config.Entrypoint,
+		cmd:        config.Cmd,
+		servables:  map[string]server.ServableConfig{},
+		files:      map[string]string{},
+	}
+
+	for _, layer := range img.BaseLayers() {
+		summary.baseLayers = append(summary.baseLayers, layer.Digest.String())
+	}
+
+	// the parts of files split by --max-layer-size are added in order, lowest layer first
+	parts := map[string]hash.Hash{}
+	partCounts := map[string]int{}
+
+	lockFileName := path.Join(constants.MetaDirName, constants.LockFileName)
+	err = img.WalkWorkspace(ctx, rc, func(name string, hdr *tar.Header, r io.Reader) error {
+		if name == lockFileName {
+			return nil
+		}
+
+		if file, index, ok := archive.ParsePartName(name); ok && hdr.Typeflag == tar.TypeReg {
+			if index != partCounts[file] {
+				return fmt.Errorf("part %d of %s follows %d parts", index, file, partCounts[file])
+			}
+			if index == 0 {
+				parts[file] = sha256.New()
+			}
+			partCounts[file]++
+
+			if _, err := io.Copy(parts[file], r); err != nil {
+				return err
+			}
+			summary.files[file] = fmt.Sprintf("sha256:%x", parts[file].Sum(nil))
+			return nil
+		}
+
+		// servables and methods follow the same layout build uses to generate server.yaml
+		dir, file := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if hdr.Typeflag == tar.TypeDir && dir == "" && !strings.HasPrefix(file, ".") {
+			summary.addServable(file)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeLink:
+			// a hard link shares the content of its target, which comes first in the layers
+			hash, ok := summary.files[hdr.Linkname]
+			if !ok {
+				return fmt.Errorf("hard link %s to %s precedes its target", name, hdr.Linkname)
+			}
+			summary.files[name] = hash
+			return nil
+		case tar.TypeSymlink:
+			summary.files[name] = "-> " + hdr.Linkname
+			return nil
+		default:
+			return nil
+		}
+
+		hash := sha256.New()
+		rdr := io.TeeReader(r, hash)
+		if dir != "" && !strings.Contains(dir, "/") && !strings.HasPrefix(dir, ".") && strings.HasPrefix(file, constants.MethodPrefix) {
+			content, err := io.ReadAll(rdr)
+			if err != nil {
+				return err
+			}
+
+			servable := summary.addServable(dir)
+			servable.Methods = append(servable.Methods, server.MethodDetail{
+				Name:   strings.TrimPrefix(RemoveFileExtension(file), constants.MethodPrefix),
+				Readme: base64.StdEncoding.EncodeToString(content),
+			})
+			summary.servables[dir] = servable
+		} else if _, err := io.Copy(io.Discard, rdr); err != nil {
+			return err
+		}
+
+		summary.files[name] = fmt.Sprintf("sha256:%x", hash.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to read model layers of %s: %w", image, err)
+	}
+
+	return
+}
+
+func (ms *modelSummary) addServable(name string) server.ServableConfig {
+	servable, ok := ms.servables[name]
+	if !ok {
+		servable = server.ServableConfig{Name: name}
+		ms.servables[name] = servable
+	}
+
+	return servable
+}
+
+func diffModelSummaries(a, b *modelSummary) (entries []DiffEntry) {
+	entries = []DiffEntry{}
+
+	if a.baseDigest != b.baseDigest || a.baseName != b.baseName {
+		entries = append(entries, DiffEntry{
+			Kind:   "base",
+			Name:   "image",
+			Change: DiffModified,
+			Old:    baseDescription(a.baseName, a.baseDigest),
+			New:    baseDescription(b.baseName, b.baseDigest),
+		})
+	} else if strings.Join(a.baseLayers, ",") != strings.Join(b.baseLayers, ",") {
+		entries = append(entries, DiffEntry{
+			Kind:   "base",
+			Name:   "layers",
+			Change: DiffModified,
+			Old:    fmt.Sprintf("%d layers", len(a.baseLayers)),
+			New:    fmt.Sprintf("%d layers", len(b.baseLayers)),
+		})
+	}
+
+	if strings.Join(a.entrypoint, " ") != strings.Join(b.entrypoint, " ") {
+		entries = append(entries, DiffEntry{
+			Kind:   "config",
+			Name:   "entrypoint",
+			Change: DiffModified,
+			Old:    strings.Join(a.entrypoint, " "),
+			New:    strings.Join(b.entrypoint, " "),
+		})
+	}
+
+	if strings.Join(a.cmd, " ") != strings.Join(b.cmd, " ") {
+		entries = append(entries, DiffEntry{
+			Kind:   "config",
+			Name:   "cmd",
+			Change: DiffModified,
+			Old:    strings.Join(a.cmd, " "),
+			New:    strings.Join(b.cmd, " "),
+		})
+	}
+
+	for _, name := range unionKeys(a.servables, b.servables) {
+		servableA, inA := a.servables[name]
+		servableB, inB := b.servables[name]
+		switch {
+		case !inA:
+			entries = append(entries, DiffEntry{Kind: "servable", Name: name, Change: DiffAdded})
+		case !inB:
+			entries = append(entries, DiffEntry{Kind: "servable", Name: name, Change: DiffRemoved})
+		default:
+			entries = append(entries, diffMethods(name, servableA.Methods, servableB.Methods)...)
+		}
+	}
+
+	for _, name := range unionKeys(a.files, b.files) {
+		hashA, inA := a.files[name]
+		hashB, inB := b.files[name]
+		switch {
+		case !inA:
+			entries = append(entries, DiffEntry{Kind: "file", Name: name, Change: DiffAdded, New: hashB})
+		case !inB:
+			entries = append(entries, DiffEntry{Kind: "file", Name: name, Change: DiffRemoved, Old: hashA})
+		case hashA != hashB:
+			entries = append(entries, DiffEntry{Kind: "file", Name: name, Change: DiffModified, Old: hashA, New: hashB})
+		}
+	}
+
+	return
+}
+
+func diffMethods(servable string, methodsA, methodsB []server.MethodDetail) (entries []DiffEntry) {
+	mapA := map[string]server.MethodDetail{}
+	for _, method := range methodsA {
+		mapA[method.Name] = method
+	}
+
+	mapB := map[string]server.MethodDetail{}
+	for _, method := range methodsB {
+		mapB[method.Name] = method
+	}
+
+	for _, name := range unionKeys(mapA, mapB) {
+		methodA, inA := mapA[name]
+		methodB, inB := mapB[name]
+		fullName := servable + "/" + name
+		switch {
+		case !inA:
+			entries = append(entries, DiffEntry{Kind: "method", Name: fullName, Change: DiffAdded})
+		case !inB:
+			entries = append(entries, DiffEntry{Kind: "method", Name: fullName, Change: DiffRemoved})
+		case methodA.Readme != methodB.Readme || methodA.Description != methodB.Description:
+			entries = append(entries, DiffEntry{Kind: "method", Name: fullName, Change: DiffModified})
+		}
+	}
+
+	return
+}
+
+func baseDescription(name, digest string) string {
+	if name == "" && digest == "" {
+		return "unknown"
+	}
+
+	return fmt.Sprintf("%s@%s", name, digest)
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
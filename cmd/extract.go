@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/imageref"
+	"github.com/edgewize-io/image-packaging-tool/pkg/modelimage"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+type ExtractOptions struct {
+	rootOpts    *RootOptions
+	image       string
+	outputDir   string
+	platform    string
+	limitRate   string
+	maxSize     string
+	maxFileSize string
+	maxFiles    int
+	sameOwner   bool
+	limits      *archive.ExtractLimits
+}
+
+func NewCmdExtract(rootOptions *RootOptions) *cobra.Command {
+	extractOptions := &ExtractOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "extract <image> <dir>",
+		Short: "extract model workspace from image",
+		Long: `Extract the layers added by packctl from a model image into a directory and
+restore .modelmesh/server.yaml, so the workspace can be audited or rebuilt.
+
+Images may come from registries that are not fully trusted, extraction stops when
+the layers exceed --max-size, --max-file-size or --max-files, and entries or links
+that would leave the extract directory are refused.`,
+		Example: `
+# restore the workspace of a model image into ./resnet
+packctl extract registry.example.org/models/resnet:v1 ./resnet`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			extractOptions.image = args[0]
+			extractOptions.outputDir = args[1]
+			err := extractOptions.validate()
+			if err != nil {
+				return err
+			}
+
+			return extractOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&extractOptions.platform, "platform", "linux/amd64", "image platform to extract, default: linux/amd64")
+	command.Flags().StringVar(&extractOptions.maxSize, "max-size", "64GiB", "maximum size of all extracted files, 0 disables the limit")
+	command.Flags().StringVar(&extractOptions.maxFileSize, "max-file-size", "32GiB", "maximum size of a single extracted file, 0 disables the limit")
+	command.Flags().IntVar(&extractOptions.maxFiles, "max-files", 1000000, "maximum number of entries in the layers, 0 disables the limit")
+	command.Flags().BoolVar(&extractOptions.sameOwner, "same-owner", false, "give the extracted files the uid and gid recorded in the image, usually requires root")
+	command.Flags().StringVar(&extractOptions.limitRate, "limit-rate", "", "limit layer download throughput per second, e.g. 2MiB, default: limitRate of the registry in the config file")
+
+	return command
+}
+
+func (eo *ExtractOptions) validate() (err error) {
+	maxSize, err := utils.ParseSize(eo.maxSize)
+	if err != nil || maxSize < 0 {
+		return fmt.Errorf("invalid --max-size %s", eo.maxSize)
+	}
+
+	maxFileSize, err := utils.ParseSize(eo.maxFileSize)
+	if err != nil || maxFileSize < 0 {
+		return fmt.Errorf("invalid --max-file-size %s", eo.maxFileSize)
+	}
+
+	if eo.maxFiles < 0 {
+		return fmt.Errorf("invalid --max-files %d", eo.maxFiles)
+	}
+
+	// the limits are shared by all layers of the image
+	eo.limits = &archive.ExtractLimits{MaxTotalSize: maxSize, MaxFileSize: maxFileSize, MaxEntries: eo.maxFiles}
+	return nil
+}
+
+func (eo *ExtractOptions) run(ctx context.Context) (err error) {
+	rSrc, err := ref.New(eo.image)
+	if err != nil {
+		return
+	}
+
+	pf, err := platform.Parse(eo.platform)
+	if err != nil {
+		err = fmt.Errorf("failed to parse platform %s: %v", eo.platform, err)
+		return
+	}
+
+	err = eo.prepareOutputDir()
+	if err != nil {
+		return
+	}
+
+	rc := eo.rootOpts.newRegClient()
+	defer rc.Close(ctx, rSrc)
+
+	img, err := modelimage.Get(ctx, rc, rSrc, pf)
+	if err != nil {
+		return
+	}
+
+	img.Limiter, err = eo.rootOpts.rateLimiter(rSrc.Registry, eo.limitRate)
+	if err != nil {
+		return
+	}
+
+	layers := img.PackctlLayers()
+	if len(layers) == 0 {
+		return fmt.Errorf("image %s has no layers", rSrc.CommonName())
+	}
+
+	if img.Workspace() == "" {
+		utils.PrintYellow(os.Stdout, fmt.Sprintf("image %s has no workspace label, searching the top layer\n", rSrc.CommonName()))
+	}
+
+	workspace, err := img.ResolveWorkspace(ctx, rc)
+	if err != nil {
+		return
+	}
+
+	for _, layer := range layers {
+		err = eo.extractLayer(ctx, rc, rSrc, layer, workspace)
+		if err != nil {
+			err = fmt.Errorf("failed to extract layer %s: %w", layer.Digest, err)
+			return
+		}
+	}
+
+	// files split by --max-layer-size are joined like start.sh does in the container
+	err = archive.JoinParts(eo.outputDir)
+	if err != nil {
+		err = fmt.Errorf("failed to join split files: %w", err)
+		return
+	}
+
+	// a lock left over from the build is of no use in the restored workspace
+	err = os.Remove(filepath.Join(eo.outputDir, constants.MetaDirName, constants.LockFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	err = eo.restoreServerFile(rSrc)
+	if err != nil {
+		return
+	}
+
+	utils.PrintString(os.Stdout, fmt.Sprintf("workspace of image %s extracted to %s successfully\n", rSrc.CommonName(), eo.outputDir))
+	return
+}
+
+func (eo *ExtractOptions) prepareOutputDir() (err error) {
+	entries, err := os.ReadDir(eo.outputDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.MkdirAll(eo.outputDir, 0755)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if len(entries) > 0 {
+		err = fmt.Errorf("extract directory [%s] is not empty", eo.outputDir)
+	}
+
+	return
+}
+
+func (eo *ExtractOptions) extractLayer(ctx context.Context, rc *regclient.RegClient, r ref.Ref, layer descriptor.Descriptor, workspace string) (err error) {
+	limiter, err := eo.rootOpts.rateLimiter(r.Registry, eo.limitRate)
+	if err != nil {
+		return
+	}
+
+	rdr, err := rc.BlobGet(ctx, r, layer)
+	if err != nil {
+		return
+	}
+
+	defer rdr.Close()
+
+	opts := []archive.TarOpts{archive.TarTrimPrefix(workspace), archive.TarLimits(eo.limits)}
+	if eo.sameOwner {
+		opts = append(opts, archive.TarSameOwner)
+	}
+
+	return archive.Extract(ctx, eo.outputDir, limiter.Reader(ctx, rdr), opts...)
+}
+
+func (eo *ExtractOptions) restoreServerFile(r ref.Ref) (err error) {
+	metaDirPath := filepath.Join(eo.outputDir, constants.MetaDirName)
+	serverConfigFilePath := filepath.Join(metaDirPath, constants.ServerConfigFile)
+
+	serverConfig := &server.ServerFile{}
+	serverConfigBytes, err := os.ReadFile(serverConfigFilePath)
+	if err == nil {
+		// images built with --read-only carry a read-only server.yaml
+		err = os.Chmod(serverConfigFilePath, 0644)
+		if err != nil {
+			return
+		}
+
+		err = yaml.Unmarshal(serverConfigBytes, serverConfig)
+		if err != nil {
+			return
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		utils.PrintYellow(os.Stdout, fmt.Sprintf("%s not found in image, creating a new one\n", constants.ServerConfigFile))
+		serverConfig.Name = path.Base(r.Repository)
+		serverConfig.Version = r.Tag
+		err = os.MkdirAll(metaDirPath, 0755)
+		if err != nil {
+			return
+		}
+	} else {
+		return
+	}
+
+	imageRef, err := imageref.NewImageRef(r.CommonName())
+	if err != nil {
+		return
+	}
+
+	serverConfig.Image = server.ImageInfo{
+		Registry:   imageRef.Registry,
+		Repository: imageRef.Repository,
+		Tag:        imageRef.Tag,
+	}
+
+	updatedServerConfigBytes, err := yaml.Marshal(serverConfig)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(serverConfigFilePath, updatedServerConfigBytes, 0666)
+}
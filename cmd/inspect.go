@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/modelimage"
+	"github.com/edgewize-io/image-packaging-tool/pkg/provenance"
+	"github.com/edgewize-io/image-packaging-tool/pkg/signature"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type InspectOptions struct {
+	rootOpts  *RootOptions
+	image     string
+	platform  string
+	key       string
+	limitRate string
+}
+
+// InspectReport describes a model image
+type InspectReport struct {
+	Image        string               `json:"image"`
+	Digest       string               `json:"digest"`
+	Platform     string               `json:"platform"`
+	BaseImage    string               `json:"baseImage,omitempty"`
+	BaseDigest   string               `json:"baseDigest,omitempty"`
+	Entrypoint   []string             `json:"entrypoint,omitempty"`
+	Cmd          []string             `json:"cmd,omitempty"`
+	Labels       map[string]string    `json:"labels,omitempty"`
+	Layers       []InspectLayer       `json:"layers"`
+	Attestations []InspectAttestation `json:"attestations"`
+}
+
+// InspectLayer is a layer of an inspected image
+type InspectLayer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Packctl   bool   `json:"packctl"`
+}
+
+// InspectAttestation is an attestation attached to an inspected image
+type InspectAttestation struct {
+	PredicateType string                `json:"predicateType"`
+	Signed        bool                  `json:"signed"`
+	Verified      bool                  `json:"verified"`
+	VerifyError   string                `json:"verifyError,omitempty"`
+	Provenance    *provenance.Statement `json:"provenance,omitempty"`
+}
+
+func NewCmdInspect(rootOptions *RootOptions) *cobra.Command {
+	inspectOptions := &InspectOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "inspect <image>",
+		Short: "show details of a model image",
+		Long: `Show the base image, config, layers and attestations of a model image,
+including the provenance recorded by packctl build.`,
+		Example: `
+# show a model image with its provenance
+packctl inspect registry.example.org/models/resnet:v1
+
+# verify the provenance signature
+packctl inspect --key packctl.pub registry.example.org/models/resnet:v1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inspectOptions.image = args[0]
+			return inspectOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&inspectOptions.platform, "platform", "linux/amd64", "image platform to inspect, default: linux/amd64")
+	command.Flags().StringVar(&inspectOptions.key, "key", "", "public key file to verify attestation signatures with")
+	command.Flags().StringVar(&inspectOptions.limitRate, "limit-rate", "", "limit attestation download throughput per second, e.g. 2MiB, default: limitRate of the registry in the config file")
+
+	return command
+}
+
+func (ins *InspectOptions) run(ctx context.Context) (err error) {
+	r, err := ref.New(ins.image)
+	if err != nil {
+		return
+	}
+
+	pf, err := platform.Parse(ins.platform)
+	if err != nil {
+		err = fmt.Errorf("failed to parse platform %s: %v", ins.platform, err)
+		return
+	}
+
+	var pub crypto.PublicKey
+	if ins.key != "" {
+		pub, err = signature.LoadPublicKey(ins.key)
+		if err != nil {
+			return
+		}
+	}
+
+	rc := ins.rootOpts.newRegClient()
+	defer rc.Close(ctx, r)
+
+	img, err := modelimage.Get(ctx, rc, r, pf)
+	if err != nil {
+		return
+	}
+
+	config := img.Config.GetConfig().Config
+	report := InspectReport{
+		Image:        r.CommonName(),
+		Digest:       img.Digest.String(),
+		Platform:     pf.String(),
+		BaseImage:    img.BaseName(),
+		BaseDigest:   img.BaseDigest(),
+		Entrypoint:   config.Entrypoint,
+		Cmd:          config.Cmd,
+		Labels:       config.Labels,
+		Layers:       []InspectLayer{},
+		Attestations: []InspectAttestation{},
+	}
+
+	baseLayerCount := len(img.BaseLayers())
+	for i, layer := range img.Layers {
+		report.Layers = append(report.Layers, InspectLayer{
+			Digest:    layer.Digest.String(),
+			MediaType: layer.MediaType,
+			Size:      layer.Size,
+			Packctl:   img.IsPackctlImage() && i >= baseLayerCount,
+		})
+	}
+
+	limiter, err := ins.rootOpts.rateLimiter(r.Registry, ins.limitRate)
+	if err != nil {
+		return
+	}
+
+	attestations, err := signature.GetAttestations(ctx, rc, r, img.Digest, limiter)
+	if err != nil {
+		return
+	}
+
+	for _, attestation := range attestations {
+		report.Attestations = append(report.Attestations, inspectAttestation(attestation, pub))
+	}
+
+	return ins.rootOpts.printResult(os.Stdout, report, report.print)
+}
+
+func inspectAttestation(attestation signature.Attestation, pub crypto.PublicKey) InspectAttestation {
+	ia := InspectAttestation{
+		PredicateType: attestation.PredicateType,
+		Signed:        len(attestation.Envelope.Signatures) > 0,
+	}
+
+	if pub != nil {
+		err := attestation.Envelope.Verify(pub)
+		if err != nil {
+			ia.VerifyError = err.Error()
+		} else {
+			ia.Verified = true
+		}
+	}
+
+	if attestation.PredicateType != provenance.PredicateSLSA {
+		return ia
+	}
+
+	statementBytes, err := attestation.Envelope.Statement()
+	if err != nil {
+		return ia
+	}
+
+	statement, err := provenance.ParseStatement(statementBytes)
+	if err == nil {
+		ia.Provenance = &statement
+	}
+
+	return ia
+}
+
+func (ir InspectReport) print(out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Image:\t%s\n", ir.Image)
+	fmt.Fprintf(tw, "Digest:\t%s\n", ir.Digest)
+	fmt.Fprintf(tw, "Platform:\t%s\n", ir.Platform)
+	if ir.BaseImage != "" {
+		fmt.Fprintf(tw, "Base image:\t%s@%s\n", ir.BaseImage, ir.BaseDigest)
+	}
+	fmt.Fprintf(tw, "Entrypoint:\t%s\n", strings.Join(ir.Entrypoint, " "))
+	if len(ir.Cmd) > 0 {
+		fmt.Fprintf(tw, "Cmd:\t%s\n", strings.Join(ir.Cmd, " "))
+	}
+
+	if len(ir.Labels) > 0 {
+		fmt.Fprintf(tw, "Labels:\n")
+		labelNames := []string{}
+		for name := range ir.Labels {
+			labelNames = append(labelNames, name)
+		}
+		sort.Strings(labelNames)
+		for _, name := range labelNames {
+			fmt.Fprintf(tw, "  %s=%s\n", name, ir.Labels[name])
+		}
+	}
+
+	fmt.Fprintf(tw, "Layers:\n")
+	for _, layer := range ir.Layers {
+		source := "base"
+		if layer.Packctl {
+			source = "packctl"
+		}
+		fmt.Fprintf(tw, "  %s %s %d\n", layer.Digest, source, layer.Size)
+	}
+
+	if len(ir.Attestations) == 0 {
+		fmt.Fprintf(tw, "Attestations:\tnone\n")
+	}
+
+	for _, attestation := range ir.Attestations {
+		fmt.Fprintf(tw, "Attestation:\t%s\n", attestation.PredicateType)
+		fmt.Fprintf(tw, "  Signed:\t%t\n", attestation.Signed)
+		if attestation.Verified {
+			fmt.Fprintf(tw, "  Verified:\ttrue\n")
+		} else if attestation.VerifyError != "" {
+			fmt.Fprintf(tw, "  Verified:\tfalse (%s)\n", attestation.VerifyError)
+		}
+
+		if attestation.Provenance != nil {
+			printProvenance(tw, attestation.Provenance.Predicate)
+		}
+	}
+
+	return tw.Flush()
+}
+
+func printProvenance(w io.Writer, predicate provenance.Predicate) {
+	fmt.Fprintf(w, "  Builder:\t%s\n", predicate.Builder.ID)
+	if predicate.Metadata.BuildStartedOn != nil && predicate.Metadata.BuildFinishedOn != nil {
+		fmt.Fprintf(w, "  Built:\t%s - %s\n", predicate.Metadata.BuildStartedOn.Format(time.RFC3339), predicate.Metadata.BuildFinishedOn.Format(time.RFC3339))
+	}
+
+	paramNames := []string{}
+	for name := range predicate.Invocation.Parameters {
+		paramNames = append(paramNames, name)
+	}
+	sort.Strings(paramNames)
+	for _, name := range paramNames {
+		fmt.Fprintf(w, "  Parameter %s:\t%s\n", name, predicate.Invocation.Parameters[name])
+	}
+
+	for _, material := range predicate.Materials {
+		digests := []string{}
+		for algo, encoded := range material.Digest {
+			digests = append(digests, algo+":"+encoded)
+		}
+		fmt.Fprintf(w, "  Material %s %s\n", material.URI, strings.Join(digests, ","))
+	}
+}
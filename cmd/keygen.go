@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/signature"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+type GenerateKeyPairOptions struct {
+	keyType   string
+	outputDir string
+	name      string
+}
+
+func NewCmdGenerateKeyPair() *cobra.Command {
+	keyOptions := &GenerateKeyPairOptions{}
+
+	command := &cobra.Command{
+		Use:   "generate-key-pair",
+		Short: "generate a key pair for signing images",
+		Long: `Generate a private key and its public key as PEM files for signing model images.
+The private key is written unencrypted with mode 0600, keep it on the build host only.`,
+		Example: `
+# generate packctl.key and packctl.pub in the current directory
+packctl generate-key-pair
+
+# generate an ed25519 key pair
+packctl generate-key-pair --type ed25519 --output-dir ~/.packctl`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return keyOptions.run()
+		},
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&keyOptions.keyType, "type", signature.KeyTypeECDSA, "key type, support: [\"ecdsa\", \"ed25519\"]")
+	flags.StringVar(&keyOptions.outputDir, "output-dir", ".", "directory to write the key pair to")
+	flags.StringVar(&keyOptions.name, "name", "packctl", "key file name, written as <name>.key and <name>.pub")
+
+	return command
+}
+
+func (ko *GenerateKeyPairOptions) run() (err error) {
+	privateKeyPath := filepath.Join(ko.outputDir, ko.name+".key")
+	publicKeyPath := filepath.Join(ko.outputDir, ko.name+".pub")
+	for _, keyPath := range []string{privateKeyPath, publicKeyPath} {
+		if _, err = os.Stat(keyPath); err == nil {
+			return fmt.Errorf("key file %s already exists", keyPath)
+		}
+	}
+
+	signer, err := signature.GenerateKey(ko.keyType)
+	if err != nil {
+		return
+	}
+
+	err = signature.WriteKeyPair(signer, privateKeyPath, publicKeyPath)
+	if err != nil {
+		return
+	}
+
+	utils.PrintString(os.Stdout, fmt.Sprintf("private key written to %s, public key written to %s\n", privateKeyPath, publicKeyPath))
+	return
+}
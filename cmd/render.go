@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/lock"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+type RenderOptions struct {
+	buildOpts *BuildOptions
+	files     []string
+	stdout    bool
+}
+
+func NewCmdRender(rootOptions *RootOptions) *cobra.Command {
+	renderOptions := &RenderOptions{
+		buildOpts: &BuildOptions{rootOpts: rootOptions},
+	}
+
+	command := &cobra.Command{
+		Use:   "render [file...]",
+		Short: "generate the serving scripts without building",
+		Long: `Generate serving_server.py and start.sh the same way packctl build does, without building
+or pushing an image, so the serving script can be tested with a local Python environment.
+Only the given files are generated, default: serving_server.py start.sh.`,
+		Example: `
+# regenerate the scripts in the workspace for GPU
+packctl render --deviceType GPU
+
+# run the serving script with the local Python environment
+packctl render serving_server.py --stdout > /tmp/serving_server.py && python /tmp/serving_server.py`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			renderOptions.files = args
+			if len(renderOptions.files) == 0 {
+				renderOptions.files = []string{constants.ServingServerFile, constants.ServingStartScript}
+			}
+
+			err := renderOptions.buildOpts.applySpec(cmd.Flags())
+			if err != nil {
+				return err
+			}
+
+			renderOptions.buildOpts.rootOpts.stdout = renderOptions.stdout
+			err = validateDeviceType(renderOptions.buildOpts.rootOpts.messages(), renderOptions.buildOpts.deviceType)
+			if err != nil {
+				return err
+			}
+
+			return renderOptions.run()
+		},
+	}
+
+	flags := command.Flags()
+	flags.StringVar(&renderOptions.buildOpts.deviceType, "deviceType", "Ascend", "device type, support: [\"CPU\", \"GPU\", \"Ascend\"], default \"Ascend\"")
+	flags.StringVar(&renderOptions.buildOpts.specFile, "spec", "", "build spec file, default: packctl.yaml in the workspace, or the build section of .modelmesh/server.yaml")
+	flags.BoolVar(&renderOptions.stdout, "stdout", false, "print the files instead of writing them to the workspace")
+
+	return command
+}
+
+func (ro *RenderOptions) run() (err error) {
+	currWorkDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	for _, file := range ro.files {
+		if file != constants.ServingServerFile && file != constants.ServingStartScript {
+			return fmt.Errorf("unknown file %s, support: %s, %s", file, constants.ServingServerFile, constants.ServingStartScript)
+		}
+	}
+
+	if ro.stdout {
+		return ro.print(currWorkDir)
+	}
+
+	lockFilePath := filepath.Join(currWorkDir, constants.MetaDirName, constants.LockFileName)
+	if !lock.LockFile(lockFilePath) {
+		utils.PrintWarning(os.Stdout, fmt.Sprintf("\ntry to lock workspace failed, please wait or clean workspace\n"))
+		return
+	}
+
+	defer lock.UnlockFile(lockFilePath)
+
+	for _, file := range ro.files {
+		if file == constants.ServingServerFile {
+			err = ro.buildOpts.renderServingServer(currWorkDir)
+		} else {
+			_, err = ro.buildOpts.createStartScript(currWorkDir)
+		}
+		if err != nil {
+			return
+		}
+
+		utils.PrintString(os.Stdout, fmt.Sprintf("%s generated successfully!\n", file))
+	}
+
+	return
+}
+
+func (ro *RenderOptions) print(currWorkDir string) (err error) {
+	for _, file := range ro.files {
+		var content []byte
+		if file == constants.ServingServerFile {
+			content, err = ro.buildOpts.servingServerContent(currWorkDir)
+		} else {
+			_, content, err = ro.buildOpts.startScriptContent(currWorkDir)
+		}
+		if err != nil {
+			return
+		}
+
+		// a single file is printed as is so it can be piped
+		if len(ro.files) > 1 {
+			fmt.Fprintf(os.Stdout, "--- %s\n", file)
+		}
+		_, err = os.Stdout.Write(content)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
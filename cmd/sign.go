@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/signature"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"slices"
+)
+
+type SignOptions struct {
+	rootOpts *RootOptions
+	image    string
+	key      string
+}
+
+func NewCmdSign(rootOptions *RootOptions) *cobra.Command {
+	signOptions := &SignOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "sign <image>",
+		Short: "sign a model image",
+		Long: `Sign the manifest of an image with a local private key and push the signature
+next to the image, in the format used by cosign.`,
+		Example: `
+# sign a model image
+packctl sign --key packctl.key registry.example.org/models/resnet:v1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			signOptions.image = args[0]
+			return signOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&signOptions.key, "key", "", "private key file used to sign the image")
+	_ = command.MarkFlagRequired("key")
+
+	return command
+}
+
+func (so *SignOptions) run(ctx context.Context) (err error) {
+	r, err := ref.New(so.image)
+	if err != nil {
+		return
+	}
+
+	signer, err := signature.LoadPrivateKey(so.key)
+	if err != nil {
+		return
+	}
+
+	return so.rootOpts.signImage(ctx, r, signer)
+}
+
+// signImage signs the image r points to and pushes the signature to its repository
+func (ro *RootOptions) signImage(ctx context.Context, r ref.Ref, signer crypto.Signer) (err error) {
+	rc := ro.newRegClient()
+	defer rc.Close(ctx, r)
+
+	dig, err := signature.SignImage(ctx, rc, r, signer)
+	if err != nil {
+		err = fmt.Errorf("failed to sign image %s: %w", r.CommonName(), err)
+		return
+	}
+
+	utils.PrintString(ro.messages(), fmt.Sprintf("image %s@%s signed successfully\n", r.CommonName(), dig))
+	return
+}
+
+// signImage signs rOut and the tags pushed to other repositories, a signature covers a single repository
+func (bo *BuildOptions) signImage(ctx context.Context, rOut ref.Ref, rTags []ref.Ref) (err error) {
+	err = bo.rootOpts.signImage(ctx, rOut, bo.signer)
+	if err != nil {
+		return
+	}
+
+	signed := []ref.Ref{rOut}
+	for _, rTag := range rTags {
+		if slices.ContainsFunc(signed, func(r ref.Ref) bool { return ref.EqualRepository(r, rTag) }) {
+			continue
+		}
+
+		err = bo.rootOpts.signImage(ctx, rTag, bo.signer)
+		if err != nil {
+			return
+		}
+
+		signed = append(signed, rTag)
+	}
+
+	return
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/server"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+type UpdateBaseOptions struct {
+	rootOpts   *RootOptions
+	baseImage  string
+	deviceType string
+}
+
+func NewCmdUpdateBase(rootOptions *RootOptions) *cobra.Command {
+	updateBaseOptions := &UpdateBaseOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "update-base",
+		Short: "refresh the pinned base image digest",
+		Long: `Resolve the base image to its current digest and record it in .modelmesh/packctl.lock
+and server.yaml, builds with --locked use this digest afterwards.`,
+		Example: `
+# re-resolve the pinned base image tag
+packctl update-base
+
+# pin a different base image
+packctl update-base --baseImage registry.example.org/mindspore/serving:2.0
+
+# re-resolve the base image of the GPU entry of a matrix build
+packctl update-base --deviceType GPU`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateBaseOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&updateBaseOptions.baseImage, "baseImage", "", "base infer model image to pin, default: the image in packctl.lock")
+	command.Flags().StringVar(&updateBaseOptions.deviceType, "deviceType", "", "pin the base image of this device type of a matrix build instead of the single base image")
+
+	return command
+}
+
+func (ubo *UpdateBaseOptions) run(ctx context.Context) (err error) {
+	currWorkDir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	metaDirPath := filepath.Join(currWorkDir, constants.MetaDirName)
+	_, err = os.Stat(metaDirPath)
+	if err != nil {
+		err = fmt.Errorf(".modelmesh not found, current workspace not initialized correctly: %w", err)
+		return
+	}
+
+	lockFilePath := filepath.Join(metaDirPath, constants.PackctlLockFile)
+	lockFile, err := server.ReadLockFile(lockFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("failed to read %s: %w", lockFilePath, err)
+		return
+	}
+
+	baseImage := ubo.baseImage
+	previousDigest := ""
+	if lockFile != nil {
+		base, _ := lockFile.GetBase(ubo.deviceType)
+		if baseImage == "" {
+			baseImage = base.Image
+		}
+		if baseImage == base.Image {
+			previousDigest = base.Digest
+		}
+	}
+
+	if baseImage == "" {
+		err = fmt.Errorf("no base image pinned yet, use --baseImage to set one")
+		return
+	}
+
+	rSrc, err := ref.New(baseImage)
+	if err != nil {
+		return
+	}
+
+	rc := ubo.rootOpts.newRegClient()
+	defer rc.Close(ctx, rSrc)
+
+	m, err := rc.ManifestHead(ctx, rSrc, regclient.WithManifestRequireDigest())
+	if err != nil {
+		err = fmt.Errorf("failed to resolve base image %s: %w", baseImage, err)
+		return
+	}
+
+	baseDigest := m.GetDescriptor().Digest
+	err = writeBaseLock(lockFilePath, ubo.deviceType, baseImage, baseDigest)
+	if err != nil {
+		return
+	}
+
+	// server.yaml records the base image of the single or first matrix image, which is pinned by a build
+	if ubo.deviceType == "" {
+		err = ubo.updateServerFile(filepath.Join(metaDirPath, constants.ServerConfigFile), &server.BaseImageInfo{
+			Image:  baseImage,
+			Digest: baseDigest.String(),
+		})
+		if err != nil {
+			return
+		}
+	} else {
+		baseImage = fmt.Sprintf("%s of deviceType %s", baseImage, ubo.deviceType)
+	}
+
+	switch previousDigest {
+	case baseDigest.String():
+		utils.PrintString(os.Stdout, fmt.Sprintf("base image %s is still %s\n", baseImage, baseDigest))
+	case "":
+		utils.PrintString(os.Stdout, fmt.Sprintf("base image %s pinned to %s\n", baseImage, baseDigest))
+	default:
+		utils.PrintYellow(os.Stdout, fmt.Sprintf("base image %s updated from %s to %s\n", baseImage, previousDigest, baseDigest))
+	}
+
+	return
+}
+
+func (ubo *UpdateBaseOptions) updateServerFile(serverConfigFilePath string, baseImageInfo *server.BaseImageInfo) (err error) {
+	serverConfigBytes, err := os.ReadFile(serverConfigFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	serverConfig := &server.ServerFile{}
+	err = yaml.Unmarshal(serverConfigBytes, serverConfig)
+	if err != nil {
+		return
+	}
+
+	serverConfig.BaseImage = baseImageInfo
+	serverConfigBytes, err = yaml.Marshal(serverConfig)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(serverConfigFilePath, serverConfigBytes, 0666)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/signature"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/regclient/regclient/types/ref"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+type VerifyImageOptions struct {
+	rootOpts *RootOptions
+	image    string
+	key      string
+}
+
+func NewCmdVerifyImage(rootOptions *RootOptions) *cobra.Command {
+	verifyOptions := &VerifyImageOptions{
+		rootOpts: rootOptions,
+	}
+
+	command := &cobra.Command{
+		Use:   "verify-image <image>",
+		Short: "verify the signature of a model image",
+		Long:  `Verify the signatures pushed next to an image against a local public key.`,
+		Example: `
+# verify a model image before deploying it
+packctl verify-image --key packctl.pub registry.example.org/models/resnet:v1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			verifyOptions.image = args[0]
+			return verifyOptions.run(cmd.Context())
+		},
+	}
+
+	command.Flags().StringVar(&verifyOptions.key, "key", "", "public key file used to verify the image")
+	_ = command.MarkFlagRequired("key")
+
+	return command
+}
+
+func (vo *VerifyImageOptions) run(ctx context.Context) (err error) {
+	r, err := ref.New(vo.image)
+	if err != nil {
+		return
+	}
+
+	pub, err := signature.LoadPublicKey(vo.key)
+	if err != nil {
+		return
+	}
+
+	rc := vo.rootOpts.newRegClient()
+	defer rc.Close(ctx, r)
+
+	dig, valid, err := signature.VerifyImage(ctx, rc, r, pub)
+	if err != nil {
+		return
+	}
+
+	utils.PrintString(os.Stdout, fmt.Sprintf("image %s@%s verified, %d valid signature(s)\n", r.CommonName(), dig, valid))
+	return
+}
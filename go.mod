@@ -5,9 +5,12 @@ go 1.21.0
 require (
 	github.com/daviddengcn/go-colortext v1.0.0
 	github.com/klauspost/compress v1.17.9
+	github.com/klauspost/pgzip v1.2.6
+	github.com/opencontainers/go-digest v1.0.0
 	github.com/regclient/regclient v0.7.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
 	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/term v0.22.0
 	gopkg.in/yaml.v3 v3.0.1
@@ -16,7 +19,5 @@ require (
 require (
 	github.com/docker/libtrust v0.0.0-20160708172513-aabc10ec26b7 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/opencontainers/go-digest v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 )
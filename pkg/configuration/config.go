@@ -27,6 +27,7 @@ type Config struct {
 	BlobLimit     int64                      `json:"blobLimit,omitempty"`
 	IncDockerCert *bool                      `json:"incDockerCert,omitempty"`
 	IncDockerCred *bool                      `json:"incDockerCred,omitempty"`
+	LimitRate     map[string]string          `json:"limitRate,omitempty"` // default blob transfer rate per registry, e.g. "2MiB"
 }
 
 // ConfigNew creates an empty configuration
@@ -5,7 +5,33 @@ const (
 	ServerConfigFile   = "server.yaml"
 	ModelServableFile  = "servable.yaml"
 	LockFileName       = "workspace.lock"
+	PackctlLockFile    = "packctl.lock"
+	BuildSpecFile      = "packctl.yaml"
 	MethodPrefix       = "method_"
 	ServingServerFile  = "serving_server.py"
 	ServingStartScript = "start.sh"
 )
+
+// DeviceTypes are the supported device types
+var DeviceTypes = []string{"CPU", "GPU", "Ascend"}
+
+const (
+	// EntrypointReplace runs start.sh instead of the base image entrypoint
+	EntrypointReplace = "replace"
+	// EntrypointChain runs the base image entrypoint with start.sh as its arguments
+	EntrypointChain = "chain"
+	// EntrypointKeep leaves the entrypoint and cmd of the base image unchanged
+	EntrypointKeep = "keep"
+	// StartShell runs start.sh, the base image must provide it unless the entrypoint is kept
+	StartShell = "/bin/bash"
+)
+
+// EntrypointModes are the supported entrypoint modes
+var EntrypointModes = []string{EntrypointReplace, EntrypointChain, EntrypointKeep}
+
+const (
+	// LabelWorkspace records the absolute workspace path the model layer was packed from
+	LabelWorkspace = "io.edgewize.packctl.workspace"
+	// LabelLayers records how many of the top image layers were added by packctl
+	LabelLayers = "io.edgewize.packctl.layers"
+)
@@ -0,0 +1,73 @@
+package imageref
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// TagParams are the values available to tag templates such as `{{.Name}}:{{.Version}}-{{.DeviceType}}`
+type TagParams struct {
+	Name       string // model name from server.yaml
+	Version    string // model version from server.yaml
+	DeviceType string
+	Platform   string
+	GitSHA     string // short commit of the workspace, empty outside a git repository
+	Date       string // build date as YYYYMMDD
+}
+
+var tagFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	// major returns "v1" for "v1.2.0"
+	"major": func(version string) string { return versionPrefix(version, 1) },
+	// majorMinor returns "v1.2" for "v1.2.0"
+	"majorMinor": func(version string) string { return versionPrefix(version, 2) },
+}
+
+// RenderTag executes tmpl with params, text without template actions is returned unchanged.
+// Referencing an empty param fails, so a missing git commit does not silently push "sha-"
+func RenderTag(tmpl string, params TagParams) (string, error) {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, nil
+	}
+
+	t, err := template.New("tag").Option("missingkey=error").Funcs(tagFuncs).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag template %s: %w", tmpl, err)
+	}
+
+	values := map[string]string{}
+	for key, value := range map[string]string{
+		"Name":       params.Name,
+		"Version":    params.Version,
+		"DeviceType": params.DeviceType,
+		"Platform":   params.Platform,
+		"GitSHA":     params.GitSHA,
+		"Date":       params.Date,
+	} {
+		if value != "" {
+			values[key] = value
+		}
+	}
+
+	output := &bytes.Buffer{}
+	err = t.Execute(output, values)
+	if err != nil {
+		return "", fmt.Errorf("failed to render tag template %s: %w", tmpl, err)
+	}
+
+	return output.String(), nil
+}
+
+func versionPrefix(version string, parts int) string {
+	version, _, _ = strings.Cut(version, "-")
+	fields := strings.Split(version, ".")
+	if len(fields) > parts {
+		fields = fields[:parts]
+	}
+
+	return strings.Join(fields, ".")
+}
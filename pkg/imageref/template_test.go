@@ -0,0 +1,50 @@
+package imageref
+
+import "testing"
+
+func TestRenderTag(t *testing.T) {
+	t.Parallel()
+	params := TagParams{
+		Name:       "resnet",
+		Version:    "v1.2.0",
+		DeviceType: "Ascend",
+		Platform:   "linux/arm64",
+		GitSHA:     "3f2a9c1d0e4b",
+	}
+
+	tests := []struct {
+		tmpl      string
+		expect    string
+		expectErr bool
+	}{
+		{tmpl: "latest", expect: "latest"},
+		{tmpl: "{{.Name}}:{{.Version}}-{{.DeviceType}}", expect: "resnet:v1.2.0-Ascend"},
+		{tmpl: "{{.Version | majorMinor}}", expect: "v1.2"},
+		{tmpl: "{{.Version | major}}-{{.DeviceType | lower}}", expect: "v1-ascend"},
+		{tmpl: "sha-{{.GitSHA}}", expect: "sha-3f2a9c1d0e4b"},
+		{tmpl: `{{.Platform | replace "/" "-"}}`, expect: "linux-arm64"},
+		{tmpl: "{{.Name}}:{{.Date}}", expectErr: true},
+		{tmpl: "{{.Unknown}}", expectErr: true},
+		{tmpl: "{{.Name", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.tmpl, func(t *testing.T) {
+			t.Parallel()
+			result, err := RenderTag(tt.tmpl, params)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("render did not fail, received %s", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to render: %v", err)
+			}
+			if result != tt.expect {
+				t.Errorf("expected %s, received %s", tt.expect, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package layercache
+
+import (
+	"context"
+	"fmt"
+	localConfig "github.com/edgewize-io/image-packaging-tool/pkg/configuration"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/mediatype"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DirName is the cache directory inside the packctl config directory
+	DirName = "cache"
+	// DefaultKeep is how many layers are kept when pruning the cache
+	DefaultKeep = 5
+	// DefaultGrace is how long a used layer is protected from pruning, a build sharing the cache may still upload it
+	DefaultGrace = 24 * time.Hour
+)
+
+// Cache stores compressed layers on disk, named by their digest
+type Cache struct {
+	dir string
+}
+
+// Entry is a compressed layer stored in the cache
+type Entry struct {
+	Path   string
+	Digest digest.Digest
+	DiffID digest.Digest
+	Size   int64
+}
+
+// DefaultDir returns ~/.packctl/cache
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, localConfig.ConfigDir, DirName), nil
+}
+
+// New opens the cache in dir, creating it when missing
+func New(dir string) (*Cache, error) {
+	err := os.MkdirAll(filepath.Join(dir, digest.Canonical.String()), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create layer cache %s: %w", dir, err)
+	}
+
+	return &Cache{dir: dir}, nil
+}
+
+// Spool runs write with an uncompressed tar writer and stores the gzip compressed result in the cache,
+// opts configure the compression, e.g. archive.CompressThreads
+func (c *Cache) Spool(write func(w io.Writer) error, opts ...archive.CompressOpts) (entry Entry, err error) {
+	fh, err := os.CreateTemp(c.dir, "spool-")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = fh.Close()
+		_ = os.Remove(fh.Name())
+	}()
+
+	lw, err := archive.NewLayerWriter(fh, archive.CompressGzip, opts...)
+	if err != nil {
+		return
+	}
+
+	err = write(lw)
+	if err != nil {
+		return
+	}
+
+	err = lw.Close()
+	if err != nil {
+		return
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return
+	}
+
+	info := lw.Info()
+	entry = Entry{
+		Digest: info.Digest,
+		DiffID: info.DiffID,
+		Size:   info.Size,
+	}
+	entry.Path = c.path(entry.Digest)
+
+	_, err = os.Stat(entry.Path)
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(entry.Path, now, now)
+		return
+	}
+
+	err = os.Rename(fh.Name(), entry.Path)
+	return
+}
+
+// Lookup returns the cached layer with digest d and marks it as used
+func (c *Cache) Lookup(d digest.Digest) (entry Entry, ok bool) {
+	entry = Entry{Path: c.path(d), Digest: d}
+	fi, err := os.Stat(entry.Path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return Entry{}, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(entry.Path, now, now)
+	entry.Size = fi.Size()
+	return entry, true
+}
+
+// Store copies the layer with digest d from r into the cache, the content is verified against d.
+// The diff id of a stored layer is not known
+func (c *Cache) Store(d digest.Digest, r io.Reader) (entry Entry, err error) {
+	err = d.Validate()
+	if err != nil {
+		return
+	}
+
+	fh, err := os.CreateTemp(c.dir, "spool-")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = fh.Close()
+		_ = os.Remove(fh.Name())
+	}()
+
+	digester := d.Algorithm().Digester()
+	size, err := io.Copy(io.MultiWriter(fh, digester.Hash()), r)
+	if err != nil {
+		return
+	}
+	if digester.Digest() != d {
+		err = fmt.Errorf("layer %s has digest %s", d, digester.Digest())
+		return
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return
+	}
+
+	entry = Entry{Path: c.path(d), Digest: d, Size: size}
+	err = os.MkdirAll(filepath.Dir(entry.Path), 0755)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(fh.Name(), entry.Path)
+	return
+}
+
+// Descriptor returns the OCI descriptor of the cached layer
+func (e Entry) Descriptor() descriptor.Descriptor {
+	return descriptor.Descriptor{
+		MediaType: mediatype.OCI1LayerGzip,
+		Digest:    e.Digest,
+		Size:      e.Size,
+	}
+}
+
+// Open opens the cached layer content
+func (e Entry) Open() (*os.File, error) {
+	return os.Open(e.Path)
+}
+
+// Exists reports whether the registry already has the layer in the repository of r
+func (e Entry) Exists(ctx context.Context, rc *regclient.RegClient, r ref.Ref) bool {
+	br, err := rc.BlobHead(ctx, r, e.Descriptor())
+	if err != nil {
+		return false
+	}
+
+	_ = br.Close()
+	return true
+}
+
+// Prune removes all but the keep most recently used layers, layers used at or after usedSince are always kept
+func (c *Cache) Prune(keep int, usedSince time.Time) (err error) {
+	entries, err := os.ReadDir(filepath.Join(c.dir, digest.Canonical.String()))
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+
+	layers := []cached{}
+	for _, entry := range entries {
+		info, _err := entry.Info()
+		if _err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		layers = append(layers, cached{path: filepath.Join(c.dir, digest.Canonical.String(), entry.Name()), modTime: info.ModTime()})
+	}
+
+	sort.Slice(layers, func(i, j int) bool {
+		return layers[i].modTime.After(layers[j].modTime)
+	})
+
+	for i := keep; i < len(layers); i++ {
+		if !layers[i].modTime.Before(usedSince) {
+			continue
+		}
+		err = os.Remove(layers[i].path)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (c *Cache) path(d digest.Digest) string {
+	return filepath.Join(c.dir, d.Algorithm().String(), d.Encoded())
+}
@@ -0,0 +1,176 @@
+package layercache
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func spoolFile(t *testing.T, cache *Cache, content string) Entry {
+	t.Helper()
+	entry, err := cache.Spool(func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		if err := tw.WriteHeader(&tar.Header{Name: "model.om", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return err
+		}
+		return tw.Close()
+	})
+	if err != nil {
+		t.Fatalf("failed to spool: %v", err)
+	}
+	return entry
+}
+
+func TestSpool(t *testing.T) {
+	t.Parallel()
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	entry := spoolFile(t, cache, "weights")
+	compressed, err := os.ReadFile(entry.Path)
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if entry.Digest != digest.FromBytes(compressed) || entry.Size != int64(len(compressed)) {
+		t.Errorf("entry %s %d does not describe the cached file", entry.Digest, entry.Size)
+	}
+
+	fh, err := entry.Open()
+	if err != nil {
+		t.Fatalf("failed to open entry: %v", err)
+	}
+	defer fh.Close()
+	dr, err := archive.Decompress(fh)
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	uncompressed, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if entry.DiffID != digest.FromBytes(uncompressed) {
+		t.Errorf("expected diff id %s, received %s", digest.FromBytes(uncompressed), entry.DiffID)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(entry.Path, old, old); err != nil {
+		t.Fatalf("failed to set times: %v", err)
+	}
+	again := spoolFile(t, cache, "weights")
+	if again != entry {
+		t.Errorf("expected the same entry, received %v and %v", entry, again)
+	}
+	if fi, err := os.Stat(entry.Path); err != nil || !fi.ModTime().After(old) {
+		t.Errorf("expected a reused entry to be marked as used: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(cache.dir, "spool-*")); len(matches) > 0 {
+		t.Errorf("expected the spool files to be removed, found %v", matches)
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	content := []byte("base layer")
+	d := digest.FromBytes(content)
+	if _, ok := cache.Lookup(d); ok {
+		t.Errorf("expected %s to be missing", d)
+	}
+
+	entry, err := cache.Store(d, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("failed to store: %v", err)
+	}
+	cached, ok := cache.Lookup(d)
+	if !ok || cached != entry || cached.Size != int64(len(content)) {
+		t.Errorf("expected %v to be cached, received %v, found %t", entry, cached, ok)
+	}
+
+	other := digest.FromString("other layer")
+	if _, err := cache.Store(other, bytes.NewReader(content)); err == nil {
+		t.Errorf("expected an error storing content that does not match the digest")
+	}
+	if _, ok := cache.Lookup(other); ok {
+		t.Errorf("expected a mismatched layer not to be cached")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	now := time.Now()
+	entries := []Entry{}
+	for i, content := range []string{"a", "b", "c", "d"} {
+		entry := spoolFile(t, cache, content)
+		used := now.Add(-time.Duration(4-i) * time.Hour)
+		if err := os.Chtimes(entry.Path, used, used); err != nil {
+			t.Fatalf("failed to set times: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// the two oldest layers are beyond keep, only the oldest was not used since usedSince
+	err = cache.Prune(2, now.Add(-210*time.Minute))
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+
+	for i, expect := range []bool{false, true, true, true} {
+		_, err := os.Stat(entries[i].Path)
+		if exists := err == nil; exists != expect {
+			t.Errorf("entry %d: expected exists %t, received %t", i, expect, exists)
+		}
+	}
+}
+
+func TestExists(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	entry := spoolFile(t, cache, "weights")
+
+	rc := regclient.New()
+	r, err := ref.New("ocidir://" + filepath.Join(t.TempDir(), "repo") + ":latest")
+	if err != nil {
+		t.Fatalf("failed to parse ref: %v", err)
+	}
+	if entry.Exists(ctx, rc, r) {
+		t.Errorf("expected the layer to be missing")
+	}
+
+	content, err := os.ReadFile(entry.Path)
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	if _, err := rc.BlobPut(ctx, r, entry.Descriptor(), bytes.NewReader(content)); err != nil {
+		t.Fatalf("failed to put blob: %v", err)
+	}
+	if !entry.Exists(ctx, rc, r) {
+		t.Errorf("expected the layer to exist")
+	}
+}
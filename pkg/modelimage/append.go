@@ -0,0 +1,339 @@
+package modelimage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"strings"
+	"time"
+)
+
+// HistoryComment marks the history entries of layers added by packctl
+const HistoryComment = "packctl"
+
+// Layer is a pushed blob to add on top of a base image
+type Layer struct {
+	Descriptor descriptor.Descriptor
+	DiffID     digest.Digest
+	CreatedBy  string
+}
+
+// Change describes what a build adds on top of its base image
+type Change struct {
+	Layers      []Layer
+	DropLayers  int // top layers of the base image removed with their history entries before Layers are added
+	Entrypoint  []string
+	Cmd         []string // replaces the base image cmd when not nil
+	Env         []string // KEY=value entries, replacing base image entries of the same key
+	Ports       []string // exposed ports such as 8080/tcp, added to the ports of the base image
+	WorkingDir  string
+	User        string
+	StopSignal  string
+	Labels      map[string]string
+	Annotations map[string]string
+	Created     time.Time
+	// throttle copying the other platforms of a manifest list to another registry, nil does not throttle
+	Download, Upload *ratelimit.Limiter
+}
+
+// Append pushes rBase with the change applied as rTgt, the layers must already exist in the rTgt repository.
+// For manifest lists only the entries matching p are changed, the others are copied unmodified
+func Append(ctx context.Context, rc *regclient.RegClient, rBase, rTgt ref.Ref, p platform.Platform, change Change) (rOut ref.Ref, err error) {
+	if change.Created.IsZero() {
+		change.Created = time.Now().UTC()
+	}
+
+	m, err := rc.ManifestGet(ctx, rBase)
+	if err != nil {
+		return
+	}
+
+	if m.IsList() {
+		m, err = appendIndex(ctx, rc, rBase, rTgt, m, p, change)
+		if err == nil {
+			err = setAnnotations(m, change.Annotations)
+		}
+	} else {
+		err = appendImage(ctx, rc, rBase, rTgt, m, change)
+	}
+	if err != nil {
+		return
+	}
+
+	err = rc.ManifestPut(ctx, rTgt, m)
+	if err != nil {
+		return
+	}
+
+	rOut = rTgt
+	return
+}
+
+func appendIndex(ctx context.Context, rc *regclient.RegClient, rBase, rTgt ref.Ref, m manifest.Manifest, p platform.Platform, change Change) (manifest.Manifest, error) {
+	mi, ok := m.(manifest.Indexer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported manifest list type: %s", m.GetDescriptor().MediaType)
+	}
+
+	descs, err := mi.GetManifestList()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := false
+	for i, desc := range descs {
+		rEntry := rBase.SetDigest(desc.Digest.String())
+		if desc.Platform == nil || !platform.Match(*desc.Platform, p) {
+			if !ref.EqualRepository(rBase, rTgt) {
+				err = Copy(ctx, rc, rEntry, rTgt.SetDigest(desc.Digest.String()), change.Download, change.Upload)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		matched = true
+		entry, err := rc.ManifestGet(ctx, rEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		err = appendImage(ctx, rc, rEntry, rTgt, entry, change)
+		if err != nil {
+			return nil, err
+		}
+
+		entryDesc := entry.GetDescriptor()
+		err = rc.ManifestPut(ctx, rTgt.SetDigest(entryDesc.Digest.String()), entry, regclient.WithManifestChild())
+		if err != nil {
+			return nil, err
+		}
+
+		descs[i].MediaType = entryDesc.MediaType
+		descs[i].Digest = entryDesc.Digest
+		descs[i].Size = entryDesc.Size
+	}
+
+	if !matched {
+		return nil, fmt.Errorf("platform %s not found in %s", p.String(), rBase.CommonName())
+	}
+
+	err = mi.SetManifestList(descs)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// appendImage adds the layers and config changes to the image manifest m, pushing the new config to rTgt
+func appendImage(ctx context.Context, rc *regclient.RegClient, rSrc, rTgt ref.Ref, m manifest.Manifest, change Change) (err error) {
+	mi, ok := m.(manifest.Imager)
+	if !ok {
+		return fmt.Errorf("unsupported manifest type: %s", m.GetDescriptor().MediaType)
+	}
+
+	configDesc, err := mi.GetConfig()
+	if err != nil {
+		return
+	}
+
+	layers, err := mi.GetLayers()
+	if err != nil {
+		return
+	}
+
+	if change.DropLayers > len(layers) {
+		return fmt.Errorf("cannot drop %d layers of an image with %d layers", change.DropLayers, len(layers))
+	}
+	layers = layers[:len(layers)-change.DropLayers]
+
+	if !ref.EqualRepository(rSrc, rTgt) {
+		for _, layer := range layers {
+			err = rc.BlobCopy(ctx, rSrc, rTgt, layer)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	oc, err := rc.BlobGetOCIConfig(ctx, rSrc, configDesc)
+	if err != nil {
+		return
+	}
+
+	img := oc.GetConfig()
+	img.RootFS.DiffIDs, img.History, err = dropLayers(img.RootFS.DiffIDs, img.History, change.DropLayers)
+	if err != nil {
+		return
+	}
+
+	layerMediaType := mediatype.OCI1LayerGzip
+	if m.GetDescriptor().MediaType == mediatype.Docker2Manifest {
+		layerMediaType = mediatype.Docker2LayerGzip
+	}
+
+	for _, layer := range change.Layers {
+		desc := layer.Descriptor
+		desc.MediaType = layerMediaType
+		layers = append(layers, desc)
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, layer.DiffID)
+		img.History = append(img.History, v1.History{
+			Created:   &change.Created,
+			CreatedBy: layer.CreatedBy,
+			Comment:   HistoryComment,
+		})
+	}
+
+	img.Created = &change.Created
+	img.Config = change.ApplyConfig(img.Config)
+
+	oc.SetConfig(img)
+	configBytes, err := oc.RawBody()
+	if err != nil {
+		return
+	}
+
+	configDesc = descriptor.Descriptor{
+		MediaType: configDesc.MediaType,
+		Digest:    digest.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+
+	_, err = rc.BlobPut(ctx, rTgt, configDesc, bytes.NewReader(configBytes))
+	if err != nil {
+		return
+	}
+
+	err = mi.SetConfig(configDesc)
+	if err != nil {
+		return
+	}
+
+	err = mi.SetLayers(layers)
+	if err != nil {
+		return
+	}
+
+	return setAnnotations(m, change.Annotations)
+}
+
+// dropLayers removes the top count layers from the diff ids and their entries from the history,
+// the history entries without a layer above them go too
+func dropLayers(diffIDs []digest.Digest, history []v1.History, count int) ([]digest.Digest, []v1.History, error) {
+	if count == 0 {
+		return diffIDs, history, nil
+	}
+	if count > len(diffIDs) {
+		return nil, nil, fmt.Errorf("cannot drop %d layers of an image with %d diff ids", count, len(diffIDs))
+	}
+
+	end := len(history)
+	for dropped := 0; dropped < count && end > 0; end-- {
+		if !history[end-1].EmptyLayer {
+			dropped++
+		}
+	}
+
+	return diffIDs[:len(diffIDs)-count], history[:end], nil
+}
+
+func setAnnotations(m manifest.Manifest, annotations map[string]string) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	ma, ok := m.(manifest.Annotator)
+	if !ok {
+		return fmt.Errorf("manifest type %s does not support annotations", m.GetDescriptor().MediaType)
+	}
+
+	for name, value := range annotations {
+		err := ma.SetAnnotation(name, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyConfig returns config with the settings of the change applied, empty settings keep the base image value.
+// config is not modified
+func (change Change) ApplyConfig(config v1.ImageConfig) v1.ImageConfig {
+	if change.Entrypoint != nil {
+		config.Entrypoint = change.Entrypoint
+	}
+	if change.Cmd != nil {
+		config.Cmd = change.Cmd
+	}
+	if change.WorkingDir != "" {
+		config.WorkingDir = change.WorkingDir
+	}
+	if change.User != "" {
+		config.User = change.User
+	}
+	if change.StopSignal != "" {
+		config.StopSignal = change.StopSignal
+	}
+
+	if len(change.Ports) > 0 {
+		ports := map[string]struct{}{}
+		for port := range config.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		for _, port := range change.Ports {
+			ports[port] = struct{}{}
+		}
+		config.ExposedPorts = ports
+	}
+
+	config.Env = mergeEnv(config.Env, change.Env)
+	if len(change.Labels) > 0 {
+		labels := map[string]string{}
+		for name, value := range config.Labels {
+			labels[name] = value
+		}
+		for name, value := range change.Labels {
+			labels[name] = value
+		}
+		config.Labels = labels
+	}
+
+	return config
+}
+
+// mergeEnv sets the KEY=value entries of change in a copy of env, keeping the order of env
+func mergeEnv(env, change []string) []string {
+	if len(change) == 0 {
+		return env
+	}
+
+	env = append([]string{}, env...)
+	for _, entry := range change {
+		key, _, _ := strings.Cut(entry, "=")
+		replaced := false
+		for i, existing := range env {
+			if existingKey, _, _ := strings.Cut(existing, "="); existingKey == key {
+				env[i] = entry
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, entry)
+		}
+	}
+
+	return env
+}
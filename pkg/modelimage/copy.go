@@ -0,0 +1,93 @@
+package modelimage
+
+import (
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// Copy copies the image rSrc to rTgt. Within a registry regclient copies the image and mounts the blobs, the blobs
+// copied to another registry are read through download and written through upload, nil limiters do not throttle
+func Copy(ctx context.Context, rc *regclient.RegClient, rSrc, rTgt ref.Ref, download, upload *ratelimit.Limiter) error {
+	if rSrc.Registry == rTgt.Registry {
+		return rc.ImageCopy(ctx, rSrc, rTgt)
+	}
+
+	return copyManifest(ctx, rc, rSrc, rTgt, download, upload, false)
+}
+
+func copyManifest(ctx context.Context, rc *regclient.RegClient, rSrc, rTgt ref.Ref, download, upload *ratelimit.Limiter, child bool) (err error) {
+	m, err := rc.ManifestGet(ctx, rSrc)
+	if err != nil {
+		return
+	}
+
+	if m.IsList() {
+		mi, ok := m.(manifest.Indexer)
+		if !ok {
+			return fmt.Errorf("unsupported manifest list type: %s", m.GetDescriptor().MediaType)
+		}
+
+		descs, err := mi.GetManifestList()
+		if err != nil {
+			return err
+		}
+
+		for _, desc := range descs {
+			err = copyManifest(ctx, rc, rSrc.SetDigest(desc.Digest.String()), rTgt.SetDigest(desc.Digest.String()), download, upload, true)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		mi, ok := m.(manifest.Imager)
+		if !ok {
+			return fmt.Errorf("unsupported manifest type: %s", m.GetDescriptor().MediaType)
+		}
+
+		config, err := mi.GetConfig()
+		if err != nil {
+			return err
+		}
+
+		layers, err := mi.GetLayers()
+		if err != nil {
+			return err
+		}
+
+		for _, desc := range append([]descriptor.Descriptor{config}, layers...) {
+			err = copyBlob(ctx, rc, rSrc, rTgt, desc, download, upload)
+			if err != nil {
+				return fmt.Errorf("failed to copy blob %s: %w", desc.Digest, err)
+			}
+		}
+	}
+
+	opts := []regclient.ManifestOpts{}
+	if child {
+		opts = append(opts, regclient.WithManifestChild())
+	}
+
+	return rc.ManifestPut(ctx, rTgt, m, opts...)
+}
+
+func copyBlob(ctx context.Context, rc *regclient.RegClient, rSrc, rTgt ref.Ref, desc descriptor.Descriptor, download, upload *ratelimit.Limiter) (err error) {
+	br, err := rc.BlobHead(ctx, rTgt, desc)
+	if err == nil {
+		return br.Close()
+	}
+
+	rdr, err := rc.BlobGet(ctx, rSrc, desc)
+	if err != nil {
+		return
+	}
+
+	defer rdr.Close()
+
+	_, err = rc.BlobPut(ctx, rTgt, desc, upload.Reader(ctx, download.Reader(ctx, rdr)))
+	return
+}
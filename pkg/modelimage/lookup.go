@@ -0,0 +1,257 @@
+package modelimage
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = archive.WhiteoutPrefix
+	whiteoutOpaque = ".wh..wh..opq"
+	// maxSymlinks limits the symlinks followed while resolving a path, like the Linux kernel
+	maxSymlinks = 40
+)
+
+// Lookup resolves name in the merged file system of the image layers, following symlinks and applying the
+// whiteouts of upper layers. The returned header carries the resolved path as Name, it is nil when name does not exist.
+// Layers are downloaded from the top until name is resolved, the compressed bytes read are written to progress
+// when it is not nil
+func (img *Image) Lookup(ctx context.Context, rc *regclient.RegClient, name string, progress io.Writer) (hdr *tar.Header, err error) {
+	tree := layeredTree{entries: fileTree{}, hidden: map[string]bool{}, opaque: map[string]bool{}}
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		entries, err := img.layerEntries(ctx, rc, img.Layers[i], progress)
+		if err != nil {
+			return nil, err
+		}
+
+		tree.addLayer(entries)
+		tree.complete = i == 0
+		hdr, known, err := tree.resolve(name)
+		if err != nil || known {
+			return hdr, err
+		}
+	}
+
+	return nil, nil
+}
+
+// ListBelow returns the entries of the merged file system of the image layers at or below each of the absolute paths,
+// sorted by name. Paths are not resolved, like the whiteouts that would remove them
+func (img *Image) ListBelow(ctx context.Context, rc *regclient.RegClient, paths []string, progress io.Writer) (entries map[string][]*tar.Header, err error) {
+	tree, err := img.tree(ctx, rc, progress)
+	if err != nil {
+		return
+	}
+
+	entries = map[string][]*tar.Header{}
+	for _, dir := range paths {
+		entries[dir] = tree.below(dir)
+	}
+
+	return
+}
+
+func (img *Image) tree(ctx context.Context, rc *regclient.RegClient, progress io.Writer) (fileTree, error) {
+	tree := fileTree{}
+	for _, layer := range img.Layers {
+		entries, err := img.layerEntries(ctx, rc, layer, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		tree.addLayer(entries)
+	}
+
+	return tree, nil
+}
+
+// layerEntries returns the headers of the entries of a layer with cleaned absolute names
+func (img *Image) layerEntries(ctx context.Context, rc *regclient.RegClient, layer descriptor.Descriptor, progress io.Writer) ([]*tar.Header, error) {
+	entries := []*tar.Header{}
+	err := img.readLayer(ctx, rc, layer, progress, func(name string, hdr *tar.Header, r io.Reader) error {
+		entries = append(entries, &tar.Header{Name: name, Typeflag: hdr.Typeflag, Linkname: hdr.Linkname, Mode: hdr.Mode, Size: hdr.Size})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer %s: %w", layer.Digest, err)
+	}
+
+	return entries, nil
+}
+
+// fileTree is the merged file system of image layers, keyed by the cleaned absolute path
+type fileTree map[string]*tar.Header
+
+// addLayer applies the whiteouts of a layer to the lower layers and adds its entries
+func (tree fileTree) addLayer(entries []*tar.Header) {
+	for _, hdr := range entries {
+		dir, base := path.Split(hdr.Name)
+		if base == whiteoutOpaque {
+			tree.remove(dir, false)
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			tree.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), true)
+		}
+	}
+
+	for _, hdr := range entries {
+		if !strings.HasPrefix(path.Base(hdr.Name), whiteoutPrefix) {
+			tree[hdr.Name] = hdr
+		}
+	}
+}
+
+// remove deletes everything below dir, and dir itself when self is set
+func (tree fileTree) remove(dir string, self bool) {
+	if self {
+		delete(tree, dir)
+	}
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name := range tree {
+		if strings.HasPrefix(name, prefix) {
+			delete(tree, name)
+		}
+	}
+}
+
+// below returns the entries at or below dir sorted by name
+func (tree fileTree) below(dir string) []*tar.Header {
+	entries := []*tar.Header{}
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name, hdr := range tree {
+		if name == dir || strings.HasPrefix(name, prefix) {
+			entries = append(entries, hdr)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// resolve follows the symlinks of name component by component, directories without an entry of their own are
+// assumed to exist as long as the final path does
+func (tree fileTree) resolve(name string) (*tar.Header, error) {
+	hdr, _, err := resolvePath(name, func(name string) (*tar.Header, bool) {
+		return tree[name], true
+	})
+	return hdr, err
+}
+
+// layeredTree is the merged file system of image layers read from the top layer down, the first entry read for a
+// path is the one visible in the image
+type layeredTree struct {
+	entries  fileTree
+	hidden   map[string]bool // paths removed by the whiteout of an upper layer, with everything below them
+	opaque   map[string]bool // directories whose entries of lower layers are hidden
+	complete bool            // every layer is read, paths without an entry do not exist
+}
+
+// addLayer adds the entries of the next lower layer that are not hidden by the layers above it
+func (tree *layeredTree) addLayer(entries []*tar.Header) {
+	whiteouts := []*tar.Header{}
+	for _, hdr := range entries {
+		if strings.HasPrefix(path.Base(hdr.Name), whiteoutPrefix) {
+			whiteouts = append(whiteouts, hdr)
+			continue
+		}
+		if _, ok := tree.entries[hdr.Name]; ok || tree.covered(hdr.Name) {
+			continue
+		}
+		tree.entries[hdr.Name] = hdr
+	}
+
+	// whiteouts only hide the entries of lower layers
+	for _, hdr := range whiteouts {
+		dir, base := path.Split(hdr.Name)
+		if base == whiteoutOpaque {
+			tree.opaque[path.Clean(dir)] = true
+		} else {
+			tree.hidden[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+		}
+	}
+}
+
+// covered reports whether the layers read so far hide name in the layers below them: name or a parent is whited
+// out, a parent is an opaque directory or a parent is not a directory
+func (tree *layeredTree) covered(name string) bool {
+	if tree.hidden[name] {
+		return true
+	}
+
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if tree.hidden[dir] || tree.opaque[dir] {
+			return true
+		}
+		if hdr, ok := tree.entries[dir]; ok && hdr.Typeflag != tar.TypeDir {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
+
+// lookup returns the visible entry of name, known is false while a lower layer could still add it
+func (tree *layeredTree) lookup(name string) (hdr *tar.Header, known bool) {
+	if hdr, ok := tree.entries[name]; ok {
+		return hdr, true
+	}
+	return nil, tree.complete || tree.covered(name)
+}
+
+// resolve resolves name like fileTree.resolve, known is false when the layers read so far do not decide it
+func (tree *layeredTree) resolve(name string) (hdr *tar.Header, known bool, err error) {
+	return resolvePath(name, tree.lookup)
+}
+
+// resolvePath follows the symlinks of name component by component with the entries returned by lookup, known is
+// false as soon as lookup does not know an entry
+func resolvePath(name string, lookup func(name string) (*tar.Header, bool)) (*tar.Header, bool, error) {
+	components := strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	current := "/"
+	for links := 0; len(components) > 0; {
+		next := path.Join(current, components[0])
+		components = components[1:]
+
+		hdr, known := lookup(next)
+		if !known {
+			return nil, false, nil
+		}
+		if hdr == nil || hdr.Typeflag != tar.TypeSymlink {
+			current = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return nil, true, fmt.Errorf("too many levels of symbolic links resolving %s", name)
+		}
+
+		target := hdr.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(current, target)
+		}
+		components = append(strings.Split(strings.TrimPrefix(path.Clean(target), "/"), "/"), components...)
+		current = "/"
+	}
+
+	hdr, known := lookup(current)
+	if !known {
+		return nil, false, nil
+	}
+	if hdr == nil {
+		return nil, true, nil
+	}
+
+	resolved := *hdr
+	resolved.Name = current
+	return &resolved, true, nil
+}
@@ -0,0 +1,163 @@
+package modelimage
+
+import (
+	"archive/tar"
+	"strings"
+	"testing"
+)
+
+func TestFileTreeResolve(t *testing.T) {
+	t.Parallel()
+	file := func(name string) *tar.Header { return &tar.Header{Name: name, Typeflag: tar.TypeReg} }
+	symlink := func(name, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+
+	tests := []struct {
+		name   string
+		layers [][]*tar.Header
+		lookup string
+		expect string
+	}{
+		{
+			name:   "file",
+			layers: [][]*tar.Header{{file("/bin/bash")}},
+			lookup: "/bin/bash",
+			expect: "/bin/bash",
+		},
+		{
+			name:   "merged usr",
+			layers: [][]*tar.Header{{symlink("/bin", "usr/bin"), file("/usr/bin/bash")}},
+			lookup: "/bin/bash",
+			expect: "/usr/bin/bash",
+		},
+		{
+			name:   "absolute symlink chain",
+			layers: [][]*tar.Header{{symlink("/bin", "/usr/bin"), symlink("/usr/bin/bash", "bash5"), file("/usr/bin/bash5")}},
+			lookup: "/bin/bash",
+			expect: "/usr/bin/bash5",
+		},
+		{
+			name:   "whiteout",
+			layers: [][]*tar.Header{{file("/bin/bash")}, {file("/bin/.wh.bash")}},
+			lookup: "/bin/bash",
+		},
+		{
+			name:   "whiteout of parent",
+			layers: [][]*tar.Header{{file("/bin/bash")}, {file("/.wh.bin")}},
+			lookup: "/bin/bash",
+		},
+		{
+			name:   "opaque directory keeps entries of the same layer",
+			layers: [][]*tar.Header{{file("/bin/sh")}, {file("/bin/bash"), file("/bin/.wh..wh..opq")}},
+			lookup: "/bin/bash",
+			expect: "/bin/bash",
+		},
+		{
+			name:   "opaque directory",
+			layers: [][]*tar.Header{{file("/bin/bash")}, {file("/bin/.wh..wh..opq"), file("/bin/sh")}},
+			lookup: "/bin/bash",
+		},
+		{
+			name:   "dangling symlink",
+			layers: [][]*tar.Header{{symlink("/bin", "usr/bin")}, {file("/bin/bash")}},
+			lookup: "/bin/bash",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tree := fileTree{}
+			for _, layer := range tt.layers {
+				tree.addLayer(layer)
+			}
+
+			hdr, err := tree.resolve(tt.lookup)
+			if err != nil {
+				t.Fatalf("failed to resolve %s: %v", tt.lookup, err)
+			}
+
+			// reading the layers from the top resolves the same
+			layered := layeredTree{entries: fileTree{}, hidden: map[string]bool{}, opaque: map[string]bool{}, complete: true}
+			for i := len(tt.layers) - 1; i >= 0; i-- {
+				layered.addLayer(tt.layers[i])
+			}
+			layeredHdr, known, err := layered.resolve(tt.lookup)
+			if err != nil || !known {
+				t.Fatalf("failed to resolve %s from the top: %v, known %t", tt.lookup, err, known)
+			}
+
+			for _, hdr := range []*tar.Header{hdr, layeredHdr} {
+				if tt.expect == "" {
+					if hdr != nil {
+						t.Errorf("expected %s to be missing, resolved to %s", tt.lookup, hdr.Name)
+					}
+					continue
+				}
+				if hdr == nil || hdr.Name != tt.expect {
+					t.Errorf("expected %s to resolve to %s, received %v", tt.lookup, tt.expect, hdr)
+				}
+			}
+		})
+	}
+
+	loop := fileTree{}
+	loop.addLayer([]*tar.Header{symlink("/a", "b"), symlink("/b", "a")})
+	if _, err := loop.resolve("/a/bash"); err == nil {
+		t.Errorf("expected an error for a symlink loop")
+	}
+}
+
+func TestLayeredTreeStopsEarly(t *testing.T) {
+	t.Parallel()
+	tree := layeredTree{entries: fileTree{}, hidden: map[string]bool{}, opaque: map[string]bool{}}
+
+	// the top layer only holds the model, lower layers may still add /bin/bash
+	tree.addLayer([]*tar.Header{{Name: "/opt/model/model.om", Typeflag: tar.TypeReg}})
+	if _, known, _ := tree.resolve("/bin/bash"); known {
+		t.Errorf("expected /bin/bash to be unknown after the top layer")
+	}
+
+	// a symlink and its target decide the lookup, the layers below are not needed
+	tree.addLayer([]*tar.Header{
+		{Name: "/bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"},
+		{Name: "/usr", Typeflag: tar.TypeDir},
+		{Name: "/usr/bin", Typeflag: tar.TypeDir},
+		{Name: "/usr/bin/bash", Typeflag: tar.TypeReg, Mode: 0755},
+	})
+	hdr, known, err := tree.resolve("/bin/bash")
+	if err != nil || !known || hdr == nil || hdr.Name != "/usr/bin/bash" {
+		t.Errorf("expected /bin/bash to resolve to /usr/bin/bash, received %v, known %t: %v", hdr, known, err)
+	}
+
+	// entries of lower layers below a whiteout are hidden without reading further
+	tree.addLayer([]*tar.Header{{Name: "/opt", Typeflag: tar.TypeDir}, {Name: "/opt/.wh.samples", Typeflag: tar.TypeReg}})
+	if hdr, known, _ := tree.resolve("/opt/samples/a.om"); !known || hdr != nil {
+		t.Errorf("expected /opt/samples/a.om to be hidden, received %v, known %t", hdr, known)
+	}
+}
+
+func TestFileTreeBelow(t *testing.T) {
+	t.Parallel()
+	tree := fileTree{}
+	for _, name := range []string{"/opt", "/opt/samples", "/opt/samples/a.om", "/opt/samples-extra", "/opt/serving/b.om"} {
+		tree[name] = &tar.Header{Name: name}
+	}
+	tree.addLayer([]*tar.Header{{Name: "/opt/serving/.wh.b.om"}})
+
+	for dir, expect := range map[string][]string{
+		"/opt/samples": {"/opt/samples", "/opt/samples/a.om"},
+		"/opt/serving": {},
+		"/missing":     {},
+	} {
+		received := []string{}
+		for _, hdr := range tree.below(dir) {
+			received = append(received, hdr.Name)
+		}
+		if strings.Join(received, ",") != strings.Join(expect, ",") {
+			t.Errorf("expected %v below %s, received %v", expect, dir, received)
+		}
+	}
+}
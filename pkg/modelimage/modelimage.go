@@ -0,0 +1,296 @@
+package modelimage
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/archive"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types"
+	"github.com/regclient/regclient/types/blob"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WalkFunc is called for every workspace entry of the packctl layers, name is relative to the workspace
+type WalkFunc func(name string, hdr *tar.Header, r io.Reader) error
+
+// Image is a single platform image resolved from a registry
+type Image struct {
+	Ref      ref.Ref
+	Digest   digest.Digest // digest the reference resolved to, the index for multi-platform images
+	Manifest manifest.Manifest
+	Config   *blob.BOCIConfig
+	Layers   []descriptor.Descriptor
+	Limiter  *ratelimit.Limiter // throttles the layer downloads, nil does not throttle
+}
+
+// Get resolves the manifest, config and layers of r for the given platform
+func Get(ctx context.Context, rc *regclient.RegClient, r ref.Ref, p platform.Platform) (img *Image, err error) {
+	m, err := rc.ManifestGet(ctx, r)
+	if err != nil {
+		return
+	}
+
+	refDigest := m.GetDescriptor().Digest
+
+	if m.IsList() {
+		var desc *descriptor.Descriptor
+		desc, err = manifest.GetPlatformDesc(m, &p)
+		if err != nil {
+			err = fmt.Errorf("platform %s not found in %s: %w", p.String(), r.CommonName(), err)
+			return
+		}
+
+		m, err = rc.ManifestGet(ctx, r, regclient.WithManifestDesc(*desc))
+		if err != nil {
+			return
+		}
+	}
+
+	mi, ok := m.(manifest.Imager)
+	if !ok {
+		err = fmt.Errorf("unsupported manifest type: %s", m.GetDescriptor().MediaType)
+		return
+	}
+
+	configDesc, err := mi.GetConfig()
+	if err != nil {
+		return
+	}
+
+	layers, err := mi.GetLayers()
+	if err != nil {
+		return
+	}
+
+	config, err := rc.BlobGetOCIConfig(ctx, r, configDesc)
+	if err != nil {
+		return
+	}
+
+	img = &Image{
+		Ref:      r,
+		Digest:   refDigest,
+		Manifest: m,
+		Config:   config,
+		Layers:   layers,
+	}
+	return
+}
+
+// Labels returns the labels of the image config
+func (img *Image) Labels() map[string]string {
+	labels := img.Config.GetConfig().Config.Labels
+	if labels == nil {
+		return map[string]string{}
+	}
+
+	return labels
+}
+
+// Workspace returns the workspace path recorded by packctl, empty when unknown
+func (img *Image) Workspace() string {
+	return img.Labels()[constants.LabelWorkspace]
+}
+
+// PackctlLayerCount returns how many of the top layers were added by packctl.
+// Images built before the layer label was recorded are assumed to have a single packctl layer.
+func (img *Image) PackctlLayerCount() int {
+	count, err := strconv.Atoi(img.Labels()[constants.LabelLayers])
+	if err != nil || count <= 0 {
+		count = 1
+	}
+
+	if count > len(img.Layers) {
+		count = len(img.Layers)
+	}
+
+	return count
+}
+
+// PackctlLayers returns the layers added by packctl, lowest first
+func (img *Image) PackctlLayers() []descriptor.Descriptor {
+	return img.Layers[len(img.Layers)-img.PackctlLayerCount():]
+}
+
+// BaseLayers returns the layers inherited from the base image
+func (img *Image) BaseLayers() []descriptor.Descriptor {
+	return img.Layers[:len(img.Layers)-img.PackctlLayerCount()]
+}
+
+// IsPackctlImage reports whether the image carries the packctl labels
+func (img *Image) IsPackctlImage() bool {
+	_, ok := img.Labels()[constants.LabelLayers]
+	return ok
+}
+
+// ResolveWorkspace returns the workspace path of the image, for images built before the
+// workspace label was recorded the top layer is searched for the .modelmesh directory
+func (img *Image) ResolveWorkspace(ctx context.Context, rc *regclient.RegClient) (workspace string, err error) {
+	workspace = img.Workspace()
+	if workspace != "" {
+		return
+	}
+
+	layers := img.PackctlLayers()
+	if len(layers) == 0 {
+		err = fmt.Errorf("image %s has no layers", img.Ref.CommonName())
+		return
+	}
+
+	serverFileSuffix := "/" + path.Join(constants.MetaDirName, constants.ServerConfigFile)
+	errFound := fmt.Errorf("found")
+	err = img.readLayer(ctx, rc, layers[len(layers)-1], nil, func(name string, hdr *tar.Header, r io.Reader) error {
+		if strings.HasSuffix(name, serverFileSuffix) {
+			workspace = strings.TrimSuffix(name, serverFileSuffix)
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return workspace, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no %s found in layer %s", constants.MetaDirName, layers[len(layers)-1].Digest)
+	}
+
+	return
+}
+
+// WalkWorkspace calls fn for every entry below the workspace in the packctl layers, lowest layer first. Names and the
+// targets of hard links are relative to the workspace
+func (img *Image) WalkWorkspace(ctx context.Context, rc *regclient.RegClient, fn WalkFunc) (err error) {
+	workspace, err := img.ResolveWorkspace(ctx, rc)
+	if err != nil {
+		return
+	}
+
+	for _, layer := range img.PackctlLayers() {
+		err = img.readLayer(ctx, rc, layer, nil, func(name string, hdr *tar.Header, r io.Reader) error {
+			rel := strings.TrimPrefix(name, workspace+"/")
+			if rel == name {
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				link := *hdr
+				link.Linkname = strings.TrimPrefix(path.Clean("/"+hdr.Linkname), workspace+"/")
+				hdr = &link
+			}
+			return fn(rel, hdr, r)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// readLayer calls fn for every entry of a layer with the cleaned absolute entry name, the compressed layer is also
+// written to progress when it is not nil
+func (img *Image) readLayer(ctx context.Context, rc *regclient.RegClient, layer descriptor.Descriptor, progress io.Writer, fn WalkFunc) (err error) {
+	rdr, err := rc.BlobGet(ctx, img.Ref, layer)
+	if err != nil {
+		return
+	}
+
+	defer rdr.Close()
+
+	r := img.Limiter.Reader(ctx, rdr)
+	if progress != nil {
+		r = io.TeeReader(r, progress)
+	}
+
+	return walkTar(r, fn)
+}
+
+// walkTar calls fn for every entry of a possibly compressed tar stream with the cleaned absolute entry name
+func walkTar(r io.Reader, fn WalkFunc) (err error) {
+	rd, err := archive.Decompress(r)
+	if err != nil {
+		return
+	}
+
+	rt := tar.NewReader(rd)
+	for {
+		hdr, err := rt.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(path.Clean("/"+hdr.Name), hdr, rt)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// maxBuildDepth limits the packctl builds BuiltOn follows down to the image they started from
+const maxBuildDepth = 16
+
+// BuiltOn returns the image the packctl builds of img started from, following the recorded base images through
+// earlier packctl builds. Images that are not packctl images are returned as is
+func (img *Image) BuiltOn(ctx context.Context, rc *regclient.RegClient, p platform.Platform) (base *Image, err error) {
+	base = img
+	for depth := 0; base.IsPackctlImage(); depth++ {
+		if depth == maxBuildDepth {
+			return nil, fmt.Errorf("more than %d packctl builds below %s", maxBuildDepth, img.Ref.CommonName())
+		}
+
+		name, baseDigest := base.BaseName(), base.BaseDigest()
+		if name == "" || baseDigest == "" {
+			return nil, fmt.Errorf("image %s does not record the image it was built on", base.Ref.CommonName())
+		}
+
+		r, err := ref.New(name)
+		if err != nil {
+			return nil, err
+		}
+
+		// the digest selects the image, the recorded tag is kept for the name of the image
+		r.Digest = baseDigest
+		base, err = Get(ctx, rc, r, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s@%s: %w", name, baseDigest, err)
+		}
+	}
+
+	return
+}
+
+// BaseName returns the base image reference recorded at build time, empty when unknown
+func (img *Image) BaseName() string {
+	return img.annotation(types.AnnotationBaseImageName)
+}
+
+// BaseDigest returns the base image digest recorded at build time, empty when unknown
+func (img *Image) BaseDigest() string {
+	return img.annotation(types.AnnotationBaseImageDigest)
+}
+
+func (img *Image) annotation(key string) string {
+	ma, ok := img.Manifest.(manifest.Annotator)
+	if !ok {
+		return ""
+	}
+
+	annotations, err := ma.GetAnnotations()
+	if err != nil {
+		return ""
+	}
+
+	return annotations[key]
+}
@@ -0,0 +1,156 @@
+package modelimage
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// LayerSource opens the possibly compressed tar stream of a layer
+type LayerSource func() (io.ReadCloser, error)
+
+// Squash writes the layers, lowest first, as a single uncompressed tar to w. Entries hidden by the whiteouts of
+// upper layers or replaced by them are dropped, the whiteouts themselves are kept at the start of the tar so they
+// still hide the files of the layers below the squashed layer.
+// Every layer is read twice, once for the headers and once for the content of the entries kept, so sources should read local copies
+func Squash(ctx context.Context, w io.Writer, layers []LayerSource) (err error) {
+	merged := squashTree{entries: map[string]int{}, whiteouts: map[string]*tar.Header{}}
+	for i, layer := range layers {
+		entries := []*tar.Header{}
+		err = walkSource(layer, func(name string, hdr *tar.Header, r io.Reader) error {
+			entry := *hdr
+			entry.Name = name
+			entries = append(entries, &entry)
+			return ctx.Err()
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read layer %d: %w", i+1, err)
+		}
+
+		merged.addLayer(i, entries)
+	}
+
+	tw := tar.NewWriter(w)
+	names := make([]string, 0, len(merged.whiteouts))
+	for name := range merged.whiteouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = tw.WriteHeader(squashHeader(name, merged.whiteouts[name]))
+		if err != nil {
+			return
+		}
+	}
+
+	for i, layer := range layers {
+		err = walkSource(layer, func(name string, hdr *tar.Header, r io.Reader) error {
+			if entryLayer, ok := merged.entries[name]; !ok || entryLayer != i || isWhiteout(name) {
+				return ctx.Err()
+			}
+
+			err := tw.WriteHeader(squashHeader(name, hdr))
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to squash layer %d: %w", i+1, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func walkSource(layer LayerSource, fn WalkFunc) error {
+	rdr, err := layer()
+	if err != nil {
+		return err
+	}
+
+	defer rdr.Close()
+	return walkTar(rdr, fn)
+}
+
+// squashTree tracks the layer every kept entry of a squash comes from, and the whiteouts of all layers
+type squashTree struct {
+	entries   map[string]int
+	whiteouts map[string]*tar.Header
+}
+
+// addLayer applies the whiteouts of layer to the entries and whiteouts of the lower layers and adds its entries,
+// an entry that is not a directory also replaces the lower entries below it
+func (tree squashTree) addLayer(layer int, entries []*tar.Header) {
+	for _, hdr := range entries {
+		dir, base := path.Split(hdr.Name)
+		if base == whiteoutOpaque {
+			tree.remove(layer, dir, false)
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			tree.remove(layer, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), true)
+		}
+	}
+
+	for _, hdr := range entries {
+		if isWhiteout(hdr.Name) {
+			tree.whiteouts[hdr.Name] = hdr
+			continue
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			tree.remove(layer, hdr.Name, false)
+		}
+		tree.entries[hdr.Name] = layer
+	}
+}
+
+// remove deletes the entries of layers below layer at or below dir, and the whiteouts of lower layers below dir
+func (tree squashTree) remove(layer int, dir string, self bool) {
+	if entryLayer, ok := tree.entries[dir]; self && ok && entryLayer < layer {
+		delete(tree.entries, dir)
+	}
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name, entryLayer := range tree.entries {
+		if entryLayer < layer && strings.HasPrefix(name, prefix) {
+			delete(tree.entries, name)
+		}
+	}
+	// the whiteouts of layer are only recorded after its whiteouts are applied
+	for name := range tree.whiteouts {
+		if strings.HasPrefix(name, prefix) {
+			delete(tree.whiteouts, name)
+		}
+	}
+}
+
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(path.Base(name), whiteoutPrefix)
+}
+
+// squashHeader returns a copy of hdr named by the cleaned absolute name, like the entries packctl adds
+func squashHeader(name string, hdr *tar.Header) *tar.Header {
+	out := *hdr
+	out.Name = name
+	if hdr.Typeflag == tar.TypeLink {
+		out.Linkname = path.Clean("/" + hdr.Linkname)
+	}
+
+	// the names are set by the fields, not by the records of the original layer
+	out.PAXRecords = nil
+	for key, value := range hdr.PAXRecords {
+		if key != "path" && key != "linkpath" {
+			if out.PAXRecords == nil {
+				out.PAXRecords = map[string]string{}
+			}
+			out.PAXRecords[key] = value
+		}
+	}
+
+	return &out
+}
@@ -0,0 +1,162 @@
+package modelimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testEntry is a layer entry, directories end with / and content is only written for regular files
+type testEntry struct {
+	name    string
+	content string
+}
+
+func testLayer(t *testing.T, entries ...testEntry) LayerSource {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(entry.content))}
+		if strings.HasSuffix(entry.name, "/") {
+			hdr = &tar.Header{Name: entry.name, Typeflag: tar.TypeDir, Mode: 0755}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("failed to write content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}
+}
+
+func TestSquash(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		layers [][]testEntry
+		expect []testEntry
+	}{
+		{
+			name: "replaced file",
+			layers: [][]testEntry{
+				{{name: "model/"}, {name: "model/a.om", content: "v1"}, {name: "model/b.om", content: "b"}},
+				{{name: "model/"}, {name: "model/a.om", content: "v2"}},
+			},
+			expect: []testEntry{{name: "/model/b.om", content: "b"}, {name: "/model"}, {name: "/model/a.om", content: "v2"}},
+		},
+		{
+			name: "whiteouts kept for the base image",
+			layers: [][]testEntry{
+				{{name: ".wh.samples"}, {name: "model/"}, {name: "model/a.om", content: "a"}, {name: "model/old/"}, {name: "model/old/c.om", content: "c"}},
+				{{name: "model/.wh.a.om"}, {name: "model/.wh.old"}},
+			},
+			expect: []testEntry{{name: "/.wh.samples"}, {name: "/model/.wh.a.om"}, {name: "/model/.wh.old"}, {name: "/model"}},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]testEntry{
+				{{name: "model/"}, {name: "model/a.om", content: "a"}, {name: "model/sub/.wh.x"}},
+				{{name: "model/.wh..wh..opq"}, {name: "model/b.om", content: "b"}},
+			},
+			expect: []testEntry{{name: "/model/.wh..wh..opq"}, {name: "/model"}, {name: "/model/b.om", content: "b"}},
+		},
+		{
+			name: "directory replaced by a file",
+			layers: [][]testEntry{
+				{{name: "model/"}, {name: "model/a.om", content: "a"}},
+				{{name: "model", content: "file"}},
+			},
+			expect: []testEntry{{name: "/model", content: "file"}},
+		},
+		{
+			name: "re-added after a whiteout",
+			layers: [][]testEntry{
+				{{name: "a.om", content: "v1"}},
+				{{name: ".wh.a.om"}},
+				{{name: "a.om", content: "v3"}},
+			},
+			expect: []testEntry{{name: "/.wh.a.om"}, {name: "/a.om", content: "v3"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			layers := []LayerSource{}
+			for _, layer := range tt.layers {
+				layers = append(layers, testLayer(t, layer...))
+			}
+
+			buf := &bytes.Buffer{}
+			if err := Squash(context.Background(), buf, layers); err != nil {
+				t.Fatalf("failed to squash: %v", err)
+			}
+
+			received := []testEntry{}
+			rt := tar.NewReader(buf)
+			for {
+				hdr, err := rt.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read squashed layer: %v", err)
+				}
+				content, err := io.ReadAll(rt)
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", hdr.Name, err)
+				}
+				received = append(received, testEntry{name: hdr.Name, content: string(content)})
+			}
+
+			if len(received) != len(tt.expect) {
+				t.Fatalf("expected %v, received %v", tt.expect, received)
+			}
+			for i := range tt.expect {
+				if received[i] != tt.expect[i] {
+					t.Errorf("entry %d: expected %v, received %v", i, tt.expect[i], received[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDropLayers(t *testing.T) {
+	t.Parallel()
+	diffIDs := []digest.Digest{digest.FromString("base"), digest.FromString("packctl 1"), digest.FromString("packctl 2")}
+	history := []v1.History{
+		{CreatedBy: "base"},
+		{CreatedBy: "ENV A=b", EmptyLayer: true},
+		{CreatedBy: "packctl 1", Comment: HistoryComment},
+		{CreatedBy: "packctl 2", Comment: HistoryComment},
+	}
+
+	receivedIDs, receivedHistory, err := dropLayers(diffIDs, history, 2)
+	if err != nil {
+		t.Fatalf("failed to drop layers: %v", err)
+	}
+	if len(receivedIDs) != 1 || receivedIDs[0] != diffIDs[0] {
+		t.Errorf("expected the base diff id, received %v", receivedIDs)
+	}
+	if len(receivedHistory) != 2 || receivedHistory[1].CreatedBy != "ENV A=b" {
+		t.Errorf("expected the base history, received %v", receivedHistory)
+	}
+
+	if _, _, err := dropLayers(diffIDs, history, 4); err == nil {
+		t.Errorf("expected an error dropping more layers than the image has")
+	}
+}
@@ -0,0 +1,188 @@
+package progress
+
+import (
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// ModeAuto redraws a progress line on terminals and prints plain lines otherwise
+	ModeAuto = "auto"
+	// ModeTTY redraws a single progress line per task
+	ModeTTY = "tty"
+	// ModePlain prints a progress line every PlainInterval
+	ModePlain = "plain"
+	// ModeNone disables progress output
+	ModeNone = "none"
+
+	PlainInterval = 10 * time.Second
+	ttyInterval   = 200 * time.Millisecond
+)
+
+// Reporter writes progress of long running tasks, it never writes to stdout so reports stay parseable
+type Reporter struct {
+	out  io.Writer
+	mode string
+}
+
+// New creates a reporter writing to out, ModeAuto picks ModeTTY when out is a terminal
+func New(out *os.File, mode string) (*Reporter, error) {
+	switch mode {
+	case ModeAuto, "":
+		mode = ModePlain
+		if term.IsTerminal(int(out.Fd())) {
+			mode = ModeTTY
+		}
+	case ModeTTY, ModePlain, ModeNone:
+	default:
+		return nil, fmt.Errorf("unknown progress mode %s, support: auto, tty, plain, none", mode)
+	}
+
+	return &Reporter{out: out, mode: mode}, nil
+}
+
+// Task tracks the progress of a single task such as a layer upload
+type Task struct {
+	r        *Reporter
+	mu       sync.Mutex
+	name     string
+	total    int64
+	done     int64
+	start    time.Time
+	lastDraw time.Time
+	drawn    bool
+}
+
+// Start begins tracking a task of total bytes, total may be 0 when unknown
+func (r *Reporter) Start(name string, total int64) *Task {
+	now := time.Now()
+	return &Task{r: r, name: name, total: total, start: now, lastDraw: now}
+}
+
+// Set records how many bytes of the task are done
+func (t *Task) Set(done int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = done
+	t.draw(false)
+}
+
+// Add records n more bytes done
+func (t *Task) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done += n
+	t.draw(false)
+}
+
+// Clear removes the progress line so other output can be printed
+func (t *Task) Clear() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.r.mode == ModeTTY && t.drawn {
+		fmt.Fprint(t.r.out, "\r\033[K")
+		t.drawn = false
+	}
+}
+
+// Done prints the final state of the task
+func (t *Task) Done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.total > 0 && t.done < t.total {
+		t.done = t.total
+	}
+	t.draw(true)
+	if t.r.mode == ModeTTY && t.drawn {
+		fmt.Fprintln(t.r.out)
+		t.drawn = false
+	}
+}
+
+// Writer counts the bytes written to it as done
+func (t *Task) Writer() io.Writer {
+	return taskWriter{t: t}
+}
+
+func (t *Task) draw(final bool) {
+	now := time.Now()
+	interval := ttyInterval
+	switch t.r.mode {
+	case ModeNone:
+		return
+	case ModePlain:
+		interval = PlainInterval
+	}
+
+	if !final && now.Sub(t.lastDraw) < interval {
+		return
+	}
+
+	t.lastDraw = now
+	line := t.line(now, final)
+	if t.r.mode == ModeTTY {
+		fmt.Fprintf(t.r.out, "\r\033[K%s", line)
+		t.drawn = true
+		return
+	}
+
+	fmt.Fprintln(t.r.out, line)
+}
+
+func (t *Task) line(now time.Time, final bool) string {
+	elapsed := now.Sub(t.start)
+	rate := float64(0)
+	if elapsed > 0 {
+		rate = float64(t.done) / elapsed.Seconds()
+	}
+
+	done := t.done
+	if t.total > 0 && done > t.total {
+		done = t.total
+	}
+
+	if final {
+		return fmt.Sprintf("%s: %s done in %s (%s/s)", t.name, FormatBytes(done), elapsed.Round(time.Second), FormatBytes(int64(rate)))
+	}
+
+	if t.total <= 0 {
+		return fmt.Sprintf("%s: %s (%s/s)", t.name, FormatBytes(done), FormatBytes(int64(rate)))
+	}
+
+	eta := "--"
+	if rate > 0 {
+		eta = time.Duration(float64(t.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s: %s / %s (%d%%) %s/s ETA %s", t.name, FormatBytes(done), FormatBytes(t.total),
+		done*100/t.total, FormatBytes(int64(rate)), eta)
+}
+
+// FormatBytes formats a byte count with binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type taskWriter struct {
+	t *Task
+}
+
+func (tw taskWriter) Write(p []byte) (int, error) {
+	tw.t.Add(int64(len(p)))
+	return len(p), nil
+}
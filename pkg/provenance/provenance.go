@@ -0,0 +1,177 @@
+package provenance
+
+import (
+	"encoding/json"
+	"github.com/edgewize-io/image-packaging-tool/pkg/regctl/version"
+	"github.com/opencontainers/go-digest"
+	"sort"
+	"time"
+)
+
+const (
+	// StatementType is the in-toto statement type
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// PredicateSLSA is the predicate type of SLSA provenance v0.2
+	PredicateSLSA = "https://slsa.dev/provenance/v0.2"
+	// BuildType identifies model image builds done by packctl
+	BuildType = "https://github.com/edgewize-io/image-packaging-tool/build@v1"
+	// BuilderID identifies packctl as the builder
+	BuilderID = "https://github.com/edgewize-io/image-packaging-tool"
+)
+
+// Statement is an in-toto statement carrying SLSA provenance
+type Statement struct {
+	Type          string    `json:"_type"`
+	PredicateType string    `json:"predicateType"`
+	Subject       []Subject `json:"subject"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Predicate is a SLSA v0.2 provenance predicate
+type Predicate struct {
+	Builder    Builder    `json:"builder"`
+	BuildType  string     `json:"buildType"`
+	Invocation Invocation `json:"invocation"`
+	Metadata   Metadata   `json:"metadata"`
+	Materials  []Material `json:"materials"`
+}
+
+// Builder identifies the tool that produced the image
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// Invocation records the parameters and environment of the build
+type Invocation struct {
+	Parameters  map[string]string `json:"parameters"`
+	Environment map[string]string `json:"environment"`
+}
+
+// Metadata records when the build ran
+type Metadata struct {
+	BuildStartedOn  *time.Time   `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time   `json:"buildFinishedOn,omitempty"`
+	Completeness    Completeness `json:"completeness"`
+	Reproducible    bool         `json:"reproducible"`
+}
+
+// Completeness states which parts of the provenance are complete
+type Completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// Material is an input of the build
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Build describes a finished packctl build
+type Build struct {
+	Image        string
+	ImageDigest  digest.Digest
+	BaseImage    string
+	BaseDigest   digest.Digest
+	Parameters   map[string]string
+	Files        map[string]digest.Digest
+	StartedOn    time.Time
+	FinishedOn   time.Time
+	ToolVersions version.Info
+}
+
+// NewStatement creates the provenance statement of a build
+func NewStatement(b Build) Statement {
+	materials := []Material{
+		{
+			URI:    b.BaseImage,
+			Digest: digestMap(b.BaseDigest),
+		},
+	}
+
+	fileNames := []string{}
+	for name := range b.Files {
+		fileNames = append(fileNames, name)
+	}
+
+	sort.Strings(fileNames)
+	for _, name := range fileNames {
+		materials = append(materials, Material{
+			URI:    "file:" + name,
+			Digest: digestMap(b.Files[name]),
+		})
+	}
+
+	return Statement{
+		Type:          StatementType,
+		PredicateType: PredicateSLSA,
+		Subject: []Subject{
+			{
+				Name:   b.Image,
+				Digest: digestMap(b.ImageDigest),
+			},
+		},
+		Predicate: Predicate{
+			Builder: Builder{
+				ID: BuilderID + "@" + ToolVersion(b.ToolVersions),
+			},
+			BuildType: BuildType,
+			Invocation: Invocation{
+				Parameters: b.Parameters,
+				Environment: map[string]string{
+					"vcsTag":     b.ToolVersions.VCSTag,
+					"vcsRef":     b.ToolVersions.VCSRef,
+					"vcsCommit":  b.ToolVersions.VCSCommit,
+					"goVersion":  b.ToolVersions.GoVer,
+					"goCompiler": b.ToolVersions.GoCompiler,
+					"platform":   b.ToolVersions.Platform,
+				},
+			},
+			Metadata: Metadata{
+				BuildStartedOn:  &b.StartedOn,
+				BuildFinishedOn: &b.FinishedOn,
+				Completeness: Completeness{
+					Parameters:  true,
+					Environment: true,
+					Materials:   true,
+				},
+			},
+			Materials: materials,
+		},
+	}
+}
+
+// Marshal encodes the statement as JSON
+func (s Statement) Marshal() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// ParseStatement decodes a JSON statement
+func ParseStatement(content []byte) (s Statement, err error) {
+	err = json.Unmarshal(content, &s)
+	return
+}
+
+func digestMap(d digest.Digest) map[string]string {
+	if d == "" {
+		return map[string]string{}
+	}
+
+	return map[string]string{d.Algorithm().String(): d.Encoded()}
+}
+
+// ToolVersion returns the version packctl reports for itself
+func ToolVersion(info version.Info) string {
+	if info.VCSTag != "" {
+		return info.VCSTag
+	}
+
+	return info.VCSRef
+}
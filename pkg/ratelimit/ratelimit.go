@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter throttles byte streams to a rate, a nil Limiter does not throttle
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// New creates a limiter for bytesPerSecond, it returns nil when bytesPerSecond is not positive
+func New(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond / 10)
+	if burst < 1024 {
+		burst = 1024
+	}
+
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may pass
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	return sleep(ctx, wait)
+}
+
+// Reader throttles reads from r
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+
+	return &reader{ctx: ctx, l: l, r: r}
+}
+
+// ReaderAt throttles reads from r
+func (l *Limiter) ReaderAt(ctx context.Context, r io.ReaderAt) io.ReaderAt {
+	if l == nil {
+		return r
+	}
+
+	return &readerAt{ctx: ctx, l: l, r: r}
+}
+
+type reader struct {
+	ctx context.Context
+	l   *Limiter
+	r   io.Reader
+}
+
+func (lr *reader) Read(p []byte) (int, error) {
+	if len(p) > lr.l.burst {
+		p = p[:lr.l.burst]
+	}
+
+	n, err := lr.r.Read(p)
+	if waitErr := lr.l.WaitN(lr.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+type readerAt struct {
+	ctx context.Context
+	l   *Limiter
+	r   io.ReaderAt
+}
+
+// ReadAt fills p in burst sized steps, as io.ReaderAt requires a full read unless an error occurs
+func (lr *readerAt) ReadAt(p []byte, off int64) (total int, err error) {
+	for total < len(p) {
+		end := total + lr.l.burst
+		if end > len(p) {
+			end = len(p)
+		}
+
+		var n int
+		n, err = lr.r.ReadAt(p[total:end], off+int64(total))
+		total += n
+		if waitErr := lr.l.WaitN(lr.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
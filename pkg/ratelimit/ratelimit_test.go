@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	content := bytes.Repeat([]byte{1}, 300<<10)
+
+	t.Run("reader", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		n, err := io.Copy(io.Discard, New(1<<20).Reader(ctx, bytes.NewReader(content)))
+		if err != nil || n != int64(len(content)) {
+			t.Fatalf("failed to read: %d, %v", n, err)
+		}
+		// the first 100KiB pass as burst, the remaining 200KiB take about 200ms
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("read was not throttled, took %s", elapsed)
+		}
+	})
+
+	t.Run("reader at", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		buf := make([]byte, len(content))
+		n, err := New(1<<20).ReaderAt(ctx, bytes.NewReader(content)).ReadAt(buf, 0)
+		if err != nil || n != len(content) || !bytes.Equal(buf, content) {
+			t.Fatalf("failed to read: %d, %v", n, err)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("read was not throttled, took %s", elapsed)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		t.Parallel()
+		var l *Limiter = New(0)
+		if l != nil {
+			t.Fatalf("expected nil limiter")
+		}
+		if r := l.Reader(ctx, bytes.NewReader(content)); r == nil {
+			t.Fatalf("expected pass through reader")
+		}
+	})
+}
@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"runtime"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
 	"github.com/ulikunitz/xz"
 )
 
@@ -32,32 +34,83 @@ var compressHeaders = map[CompressType][]byte{
 	CompressZstd:  []byte("\x28\xB5\x2F\xFD"),
 }
 
-func Compress(r io.Reader, oComp CompressType) (io.ReadCloser, error) {
+// gzipBlockSize is the input compressed by each gzip worker, it is fixed so the output does not depend on the threads
+const gzipBlockSize = 1 << 20
+
+// CompressOpts configures options for Compress and NewCompressWriter
+type CompressOpts func(*compressOpts)
+
+type compressOpts struct {
+	threads int
+}
+
+// CompressThreads option to compress blocks of the stream on up to n goroutines, 0 uses one per CPU.
+// The output is the same for any n, gzip writes a single standard stream with the blocks joined by sync flushes
+func CompressThreads(n int) CompressOpts {
+	return func(co *compressOpts) {
+		co.threads = n
+	}
+}
+
+func Compress(r io.Reader, oComp CompressType, opts ...CompressOpts) (io.ReadCloser, error) {
+	switch oComp {
+	// note, bzip2 compression is not supported
+	case CompressGzip, CompressXz, CompressZstd:
+		return writeToRead(r, func(w io.Writer) (io.WriteCloser, error) {
+			return NewCompressWriter(w, oComp, opts...)
+		})
+	case CompressNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, ErrUnknownType
+	}
+}
+
+// NewCompressWriter returns a writer compressing to w, Close must be called to flush the end of the stream
+func NewCompressWriter(w io.Writer, oComp CompressType, opts ...CompressOpts) (io.WriteCloser, error) {
+	co := compressOpts{}
+	for _, opt := range opts {
+		opt(&co)
+	}
+
 	switch oComp {
 	// note, bzip2 compression is not supported
 	case CompressGzip:
-		return writeToRead(r, newGzipWriter)
+		return newGzipWriter(w, co.workers())
 	case CompressXz:
-		return writeToRead(r, xz.NewWriter)
+		return xz.NewWriter(w)
 	case CompressZstd:
-		return writeToRead(r, newZstdWriter)
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(co.workers()))
 	case CompressNone:
-		return io.NopCloser(r), nil
+		return nopWriteCloser{w}, nil
 	default:
 		return nil, ErrUnknownType
 	}
 }
 
-// newGzipWriter generates a writer and an always nil error.
-func newGzipWriter(w io.Writer) (io.WriteCloser, error) {
-	return gzip.NewWriter(w), nil
+func (co compressOpts) workers() int {
+	if co.threads <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return co.threads
+}
+
+// newGzipWriter generates a writer compressing blocks in parallel.
+func newGzipWriter(w io.Writer, threads int) (io.WriteCloser, error) {
+	gw := pgzip.NewWriter(w)
+	err := gw.SetConcurrency(gzipBlockSize, threads)
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
 }
 
-// newZstdWriter generates a writer with the default options.
-func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
-	return zstd.NewWriter(w)
+type nopWriteCloser struct {
+	io.Writer
 }
 
+func (nopWriteCloser) Close() error { return nil }
+
 // writeToRead uses a pipe + goroutine + copy to switch from a writer to a reader.
 func writeToRead[wc io.WriteCloser](src io.Reader, newWriterFn func(io.Writer) (wc, error)) (io.ReadCloser, error) {
 	pr, pw := io.Pipe()
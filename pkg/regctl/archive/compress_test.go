@@ -92,3 +92,51 @@ func FuzzRoundTrip(f *testing.F) {
 		}
 	})
 }
+
+func TestCompressThreads(t *testing.T) {
+	t.Parallel()
+	// several gzip blocks of compressible but not repeating content
+	content := make([]byte, 3*gzipBlockSize+12345)
+	for i := range content {
+		content[i] = byte(i*i>>7) % 61
+	}
+
+	for _, algo := range []CompressType{CompressGzip, CompressZstd} {
+		algo := algo
+		t.Run(algo.String(), func(t *testing.T) {
+			t.Parallel()
+			var first []byte
+			for _, threads := range []int{1, 4, 0} {
+				var buf bytes.Buffer
+				cw, err := NewCompressWriter(&buf, algo, CompressThreads(threads))
+				if err != nil {
+					t.Fatalf("failed to create writer: %v", err)
+				}
+				if _, err := cw.Write(content); err != nil {
+					t.Fatalf("failed to compress: %v", err)
+				}
+				if err := cw.Close(); err != nil {
+					t.Fatalf("failed to close: %v", err)
+				}
+
+				dr, err := Decompress(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("failed to decompress: %v", err)
+				}
+				out, err := io.ReadAll(dr)
+				if err != nil {
+					t.Fatalf("failed to ReadAll: %v", err)
+				}
+				if !bytes.Equal(content, out) {
+					t.Fatalf("output mismatch with %d threads", threads)
+				}
+
+				if first == nil {
+					first = buf.Bytes()
+				} else if !bytes.Equal(first, buf.Bytes()) {
+					t.Errorf("output differs with %d threads", threads)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package archive
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrNotImplemented used for routines that need to be developed still
@@ -10,4 +13,29 @@ var (
 	// ErrXzUnsupported because there isn't a Go package for this and I'm
 	// avoiding dependencies on external binaries
 	ErrXzUnsupported = errors.New("xz compression is currently unsupported")
+	// ErrUnsafeLink used for links that would place or point an entry outside of the extract directory
+	ErrUnsafeLink = errors.New("link escapes the extract directory")
+	// ErrUnsafePath used for entry names that leave the extract directory
+	ErrUnsafePath = errors.New("entry name escapes the extract directory")
+	// ErrEntryLimit used when an archive has more entries than ExtractLimits.MaxEntries
+	ErrEntryLimit = errors.New("archive exceeds the entry limit")
+	// ErrFileSizeLimit used when a file is larger than ExtractLimits.MaxFileSize
+	ErrFileSizeLimit = errors.New("file exceeds the size limit")
+	// ErrTotalSizeLimit used when the extracted files exceed ExtractLimits.MaxTotalSize
+	ErrTotalSizeLimit = errors.New("archive exceeds the total size limit")
 )
+
+// LimitError reports the entry that exceeded an extraction limit, errors.Is matches the limit error in Err
+type LimitError struct {
+	Err   error
+	Name  string
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v at %s, limit %d", e.Err, e.Name, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"context"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// LayerInfo describes the stream written by a LayerWriter
+type LayerInfo struct {
+	Compression CompressType
+	// Digest and Size of the compressed stream, as listed in the image manifest
+	Digest digest.Digest
+	Size   int64
+	// DiffID and DiffSize of the uncompressed stream, DiffID is listed in the rootfs of the image config
+	DiffID   digest.Digest
+	DiffSize int64
+}
+
+// LayerWriter hashes the uncompressed stream written to it, compresses it and hashes the compressed result
+// on its way to the underlying writer, so a layer is described without reading it a second time
+type LayerWriter struct {
+	compression CompressType
+	diffID      digest.Digester
+	diffSize    countingWriter
+	compressed  digest.Digester
+	size        countingWriter
+	cw          io.WriteCloser
+	w           io.Writer
+}
+
+// NewLayerWriter returns a LayerWriter compressing to w with comp, Close must be called before Info
+func NewLayerWriter(w io.Writer, comp CompressType, opts ...CompressOpts) (*LayerWriter, error) {
+	lw := &LayerWriter{
+		compression: comp,
+		diffID:      digest.Canonical.Digester(),
+		compressed:  digest.Canonical.Digester(),
+	}
+
+	cw, err := NewCompressWriter(io.MultiWriter(w, lw.compressed.Hash(), &lw.size), comp, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	lw.cw = cw
+	lw.w = io.MultiWriter(cw, lw.diffID.Hash(), &lw.diffSize)
+	return lw, nil
+}
+
+// Write adds uncompressed bytes to the layer
+func (lw *LayerWriter) Write(p []byte) (int, error) {
+	return lw.w.Write(p)
+}
+
+// Close flushes the end of the compressed stream, it does not close the underlying writer
+func (lw *LayerWriter) Close() error {
+	return lw.cw.Close()
+}
+
+// Info returns the sizes and digests of the layer, it is only complete after Close
+func (lw *LayerWriter) Info() LayerInfo {
+	return LayerInfo{
+		Compression: lw.compression,
+		Digest:      lw.compressed.Digest(),
+		Size:        lw.size.n,
+		DiffID:      lw.diffID.Digest(),
+		DiffSize:    lw.diffSize.n,
+	}
+}
+
+// TarLayer tars path into w in a single pass, compressed with gzip when TarCompressGzip is set, and returns the
+// sizes and digests of the layer
+func TarLayer(ctx context.Context, path string, w io.Writer, opts ...TarOpts) (info LayerInfo, err error) {
+	to := tarOpts{}
+	for _, opt := range opts {
+		opt(&to)
+	}
+
+	comp := CompressNone
+	if to.compress == "gzip" {
+		comp = CompressGzip
+	}
+
+	lw, err := NewLayerWriter(w, comp, CompressThreads(to.threads))
+	if err != nil {
+		return
+	}
+
+	// the LayerWriter compresses, Tar writes the uncompressed stream
+	err = Tar(ctx, path, lw, append(opts[:len(opts):len(opts)], TarUncompressed)...)
+	if err != nil {
+		return
+	}
+
+	err = lw.Close()
+	if err != nil {
+		return
+	}
+
+	return lw.Info(), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTarLayer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "model.om"), bytes.Repeat([]byte("weights"), 1<<18), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	uncompressed := &bytes.Buffer{}
+	if err := Tar(ctx, srcDir, uncompressed); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []TarOpts
+		comp CompressType
+	}{
+		{name: "uncompressed", comp: CompressNone},
+		{name: "gzip", opts: []TarOpts{TarCompressGzip, TarCompressThreads(2)}, comp: CompressGzip},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			buf := &bytes.Buffer{}
+			info, err := TarLayer(ctx, srcDir, buf, tc.opts...)
+			if err != nil {
+				t.Fatalf("failed to tar layer: %v", err)
+			}
+
+			if info.Compression != tc.comp {
+				t.Errorf("compression mismatch: expected %s, received %s", tc.comp, info.Compression)
+			}
+			if info.Digest != digest.FromBytes(buf.Bytes()) || info.Size != int64(buf.Len()) {
+				t.Errorf("compressed mismatch: expected %s %d, received %s %d", digest.FromBytes(buf.Bytes()), buf.Len(), info.Digest, info.Size)
+			}
+			if info.DiffID != digest.FromBytes(uncompressed.Bytes()) || info.DiffSize != int64(uncompressed.Len()) {
+				t.Errorf("uncompressed mismatch: expected %s %d, received %s %d", digest.FromBytes(uncompressed.Bytes()), uncompressed.Len(), info.DiffID, info.DiffSize)
+			}
+
+			dr, err := Decompress(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("failed to decompress: %v", err)
+			}
+			out, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatalf("failed to ReadAll: %v", err)
+			}
+			if !bytes.Equal(out, uncompressed.Bytes()) {
+				t.Errorf("decompressed layer differs from the tar")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"archive/tar"
+)
+
+// ExtractLimits bounds what Extract writes, zero values are unlimited.
+// The usage accumulates over every Extract the limits are passed to, so one value limits all layers of an image
+type ExtractLimits struct {
+	// MaxTotalSize is the maximum number of bytes of all extracted files
+	MaxTotalSize int64
+	// MaxEntries is the maximum number of tar entries read, including entries that are skipped
+	MaxEntries int
+	// MaxFileSize is the maximum size of a single file
+	MaxFileSize int64
+
+	totalSize int64
+	entries   int
+}
+
+func (l *ExtractLimits) addEntry(hdr *tar.Header) error {
+	l.entries++
+	if l.MaxEntries > 0 && l.entries > l.MaxEntries {
+		return &LimitError{Err: ErrEntryLimit, Name: hdr.Name, Limit: int64(l.MaxEntries)}
+	}
+
+	return nil
+}
+
+func (l *ExtractLimits) addFile(hdr *tar.Header) error {
+	if l.MaxFileSize > 0 && hdr.Size > l.MaxFileSize {
+		return &LimitError{Err: ErrFileSizeLimit, Name: hdr.Name, Limit: l.MaxFileSize}
+	}
+
+	l.totalSize += hdr.Size
+	if l.MaxTotalSize > 0 && l.totalSize > l.MaxTotalSize {
+		return &LimitError{Err: ErrTotalSizeLimit, Name: hdr.Name, Limit: l.MaxTotalSize}
+	}
+
+	return nil
+}
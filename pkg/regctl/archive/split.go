@@ -0,0 +1,246 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// PartSuffix is inserted between the name of a split file and the number of the part
+	PartSuffix = ".packctl-part-"
+	// entryOverhead is reserved for the tar headers and padding of every entry of a layer
+	entryOverhead = 2048
+	// tarTrailer is the end of archive marker of a layer
+	tarTrailer = 1024
+)
+
+// FilePart is a byte range of a file that Tar adds as a file of its own, named by PartName
+type FilePart struct {
+	Index  int
+	Offset int64
+	Size   int64
+}
+
+// LayerFiles are the files of one layer of a split workspace, keyed by the path relative to the tar root.
+// Files without parts are added whole
+type LayerFiles map[string][]FilePart
+
+// PartName returns the name of part index of the file name, parts sort in the order they are joined
+func PartName(name string, index int) string {
+	return fmt.Sprintf("%s%s%04d", name, PartSuffix, index)
+}
+
+// ParsePartName returns the file name and index of a part named by PartName, ok is false for other names
+func ParsePartName(name string) (file string, index int, ok bool) {
+	i := strings.LastIndex(name, PartSuffix)
+	if i < 0 {
+		return "", 0, false
+	}
+
+	number := name[i+len(PartSuffix):]
+	if len(number) < 4 || strings.Trim(number, "0123456789") != "" {
+		return "", 0, false
+	}
+
+	index, err := strconv.Atoi(number)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return name[:i], index, true
+}
+
+// JoinPartDigests replaces the digests of the parts of split files by the digest of the whole file below dir,
+// digests is keyed by the path relative to dir as recorded by TarDigests
+func JoinPartDigests(dir string, digests map[string]digest.Digest) error {
+	files := map[string]bool{}
+	for name := range digests {
+		if file, _, ok := ParsePartName(name); ok {
+			files[file] = true
+			delete(digests, name)
+		}
+	}
+
+	for file := range files {
+		//#nosec G304 split files are below the workspace directory
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+
+		digests[file], err = digest.Canonical.FromReader(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// SplitLayers partitions the files of entries into layers of at most maxSize bytes of uncompressed tar, in the order
+// of entries. The directories are repeated in every layer, files are kept whole where possible and files larger than
+// a layer are split into parts that are joined again by JoinParts. There is always at least one layer
+func SplitLayers(entries []ListEntry, maxSize int64) []LayerFiles {
+	fixed := int64(tarTrailer)
+	for _, entry := range entries {
+		if entry.Mode.IsDir() {
+			fixed += entryOverhead
+		}
+	}
+
+	// the files of a layer share what the directories leave
+	maxSize -= fixed
+	partSize := maxSize - entryOverhead
+	if partSize <= 0 {
+		partSize = maxSize
+	}
+	if partSize <= 0 {
+		partSize = 1
+	}
+
+	layers := []LayerFiles{}
+	current, currentSize := LayerFiles{}, int64(0)
+	add := func(name string, size int64, parts ...FilePart) {
+		if currentSize+size > maxSize && len(current) > 0 {
+			layers = append(layers, current)
+			current, currentSize = LayerFiles{}, 0
+		}
+		current[name] = append(current[name], parts...)
+		currentSize += size
+	}
+
+	for _, entry := range entries {
+		if entry.Mode.IsDir() {
+			continue
+		}
+
+		if entry.Size+entryOverhead <= maxSize {
+			add(entry.Name, entry.Size+entryOverhead)
+			continue
+		}
+
+		for index, offset := 0, int64(0); offset < entry.Size; index, offset = index+1, offset+partSize {
+			size := partSize
+			if entry.Size-offset < size {
+				size = entry.Size - offset
+			}
+			add(entry.Name, size+entryOverhead, FilePart{Index: index, Offset: offset, Size: size})
+		}
+	}
+
+	return append(layers, current)
+}
+
+// TarFiles option to only add the files of a layer returned by SplitLayers, directories are added to every layer
+// so they keep their owner and permissions
+func TarFiles(files LayerFiles) TarOpts {
+	return func(to *tarOpts) {
+		to.files = files
+	}
+}
+
+// JoinParts replaces the parts of split files below dir by the joined files, with the permissions of the first part
+func JoinParts(dir string) error {
+	firstParts := []string{}
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && strings.HasSuffix(file, PartSuffix+"0000") {
+			firstParts = append(firstParts, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, firstPart := range firstParts {
+		err = joinFile(strings.TrimSuffix(firstPart, PartName("", 0)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinFile(name string) (err error) {
+	fi, err := os.Stat(PartName(name, 0))
+	if err != nil {
+		return
+	}
+
+	tmpName := name + PartSuffix + "tmp"
+	fh, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = fh.Close()
+		if err != nil {
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	parts := []string{}
+	for index := 0; ; index++ {
+		part := PartName(name, index)
+		_, err = os.Lstat(part)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return
+		}
+
+		err = appendFile(fh, part)
+		if err != nil {
+			return fmt.Errorf("failed to join %s: %w", part, err)
+		}
+		parts = append(parts, part)
+	}
+
+	err = fh.Chmod(fi.Mode().Perm())
+	if err != nil {
+		return
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmpName, name)
+	if err != nil {
+		return
+	}
+
+	for _, part := range parts {
+		err = os.Remove(part)
+		if err != nil {
+			return
+		}
+	}
+
+	return os.Chtimes(name, fi.ModTime(), fi.ModTime())
+}
+
+func appendFile(w io.Writer, name string) error {
+	//#nosec G304 parts are found below the extracted directory
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSplitLayers(t *testing.T) {
+	t.Parallel()
+	file := func(name string, size int64) ListEntry { return ListEntry{Name: name, Size: size, Mode: 0644} }
+	dir := func(name string) ListEntry { return ListEntry{Name: name, Mode: fs.ModeDir | 0755} }
+
+	tests := []struct {
+		name    string
+		entries []ListEntry
+		maxSize int64
+		expect  []LayerFiles
+	}{
+		{
+			name:    "empty",
+			entries: []ListEntry{dir("model")},
+			maxSize: 10000,
+			expect:  []LayerFiles{{}},
+		},
+		{
+			name:    "single layer",
+			entries: []ListEntry{dir("model"), file("model/a", 100), file("start.sh", 100)},
+			maxSize: 10000,
+			expect:  []LayerFiles{{"model/a": nil, "start.sh": nil}},
+		},
+		{
+			name:    "whole files",
+			entries: []ListEntry{file("a", 2000), file("b", 2000), file("c", 2000)},
+			maxSize: 10000,
+			expect:  []LayerFiles{{"a": nil, "b": nil}, {"c": nil}},
+		},
+		{
+			name:    "split file",
+			entries: []ListEntry{file("a", 1000), file("model.om", 20000), file("z", 10)},
+			maxSize: 10000,
+			expect: []LayerFiles{
+				{"a": nil},
+				{"model.om": {{Index: 0, Offset: 0, Size: 6928}}},
+				{"model.om": {{Index: 1, Offset: 6928, Size: 6928}}},
+				{"model.om": {{Index: 2, Offset: 13856, Size: 6144}}},
+				{"z": nil},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			layers := SplitLayers(tt.entries, tt.maxSize)
+			if !reflect.DeepEqual(layers, tt.expect) {
+				t.Errorf("expected %v, received %v", tt.expect, layers)
+			}
+		})
+	}
+}
+
+func TestSplitJoinRoundtrip(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	files := map[string][]byte{
+		"model/model.om": bytes.Repeat([]byte("0123456789abcdef"), 4000),
+		"model/config":   []byte("config"),
+		"start.sh":       []byte("#!/bin/bash"),
+	}
+	for name, content := range files {
+		fn := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fn, content, 0640); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	entries, err := List(ctx, srcDir)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	layers := SplitLayers(entries, 20000)
+	if len(layers) != 6 {
+		t.Fatalf("expected 6 layers, received %d: %v", len(layers), layers)
+	}
+
+	outDir := t.TempDir()
+	digests := map[string]digest.Digest{}
+	for i, layer := range layers {
+		buf := &bytes.Buffer{}
+		if err := Tar(ctx, srcDir, buf, TarFiles(layer), TarDigests(digests)); err != nil {
+			t.Fatalf("failed to tar layer %d: %v", i, err)
+		}
+		if buf.Len() > 20000 {
+			t.Errorf("layer %d exceeds the limit: %d bytes", i, buf.Len())
+		}
+		if err := Extract(ctx, outDir, bytes.NewReader(buf.Bytes()), TarTrimPrefix(srcDir)); err != nil {
+			t.Fatalf("failed to extract layer %d: %v", i, err)
+		}
+	}
+
+	if err := JoinPartDigests(srcDir, digests); err != nil {
+		t.Fatalf("failed to join part digests: %v", err)
+	}
+	if len(digests) != len(files) {
+		t.Errorf("expected a digest per file, received %v", digests)
+	}
+	for name, content := range files {
+		if digests[name] != digest.FromBytes(content) {
+			t.Errorf("expected digest %s for %s, received %s", digest.FromBytes(content), name, digests[name])
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(outDir, PartName("model/model.om", 0))); err != nil {
+		t.Fatalf("expected the first part to be extracted: %v", err)
+	}
+	if err := JoinParts(outDir); err != nil {
+		t.Fatalf("failed to join parts: %v", err)
+	}
+
+	for name, content := range files {
+		out, err := os.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !bytes.Equal(out, content) {
+			t.Errorf("content mismatch for %s", name)
+		}
+	}
+
+	fi, err := os.Stat(filepath.Join(outDir, "model/model.om"))
+	if err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("expected the joined file to keep mode 0640: %v %v", fi, err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "model", "*"+PartSuffix+"*")); len(matches) > 0 {
+		t.Errorf("expected the parts to be removed, found %v", matches)
+	}
+}
+
+func TestParsePartName(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		file  string
+		index int
+		ok    bool
+	}{
+		{name: PartName("model/model.om", 0), file: "model/model.om", index: 0, ok: true},
+		{name: PartName("model.packctl-part-0001", 12), file: "model.packctl-part-0001", index: 12, ok: true},
+		{name: "model/model.om"},
+		{name: "model/model.om" + PartSuffix + "tmp"},
+		{name: "model/model.om" + PartSuffix + "1"},
+	}
+
+	for _, tt := range tests {
+		file, index, ok := ParsePartName(tt.name)
+		if file != tt.file || index != tt.index || ok != tt.ok {
+			t.Errorf("%s: expected %q %d %t, received %q %d %t", tt.name, tt.file, tt.index, tt.ok, file, index, ok)
+		}
+	}
+}
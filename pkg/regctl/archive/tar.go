@@ -2,23 +2,49 @@ package archive
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/opencontainers/go-digest"
 )
 
+// WhiteoutPrefix marks an entry of a layer that removes the entry without the prefix from the lower layers
+const WhiteoutPrefix = ".wh."
+
 // TarOpts configures options for Create/Extract tar
 type TarOpts func(*tarOpts)
 
 // TODO: add support for compressed files with bzip
 type tarOpts struct {
 	// allowRelative bool // allow relative paths outside of target folder
-	compress string
+	compress    string
+	threads     int
+	trimPrefix  string
+	digests     map[string]digest.Digest
+	exclude     []string
+	ignore      []string
+	include     []string
+	dirTime     *time.Time
+	owner       *tarOwner
+	fileMode    fs.FileMode
+	dirMode     fs.FileMode
+	readOnly    bool
+	dereference bool
+	limits      *ExtractLimits
+	sameOwner   bool
+	files       LayerFiles
+	whiteouts   []string
+}
+
+type tarOwner struct {
+	uid, gid int
 }
 
 // TarCompressGzip option to use gzip compression on tar files
@@ -26,8 +52,108 @@ func TarCompressGzip(to *tarOpts) {
 	to.compress = "gzip"
 }
 
-// TarUncompressed option to tar (noop)
+// TarCompressThreads option to compress on up to n goroutines, 0 uses one per CPU, see CompressThreads
+func TarCompressThreads(n int) TarOpts {
+	return func(to *tarOpts) {
+		to.threads = n
+	}
+}
+
+// TarUncompressed option to tar without compression, this overrides an earlier TarCompressGzip
 func TarUncompressed(to *tarOpts) {
+	to.compress = ""
+}
+
+// TarDigests option to record the sha256 digest of every regular file, keyed by the path relative to the tar root
+func TarDigests(digests map[string]digest.Digest) TarOpts {
+	return func(to *tarOpts) {
+		to.digests = digests
+	}
+}
+
+// TarExclude option to skip entries whose path relative to the tar root matches one of the patterns, see path.Match
+func TarExclude(patterns ...string) TarOpts {
+	return func(to *tarOpts) {
+		to.exclude = append(to.exclude, patterns...)
+	}
+}
+
+// TarIgnore option to skip entries matching one of the patterns like a .gitignore: a pattern without a slash matches
+// the name of a file or directory at any depth, other patterns match the path relative to the tar root. A ** segment
+// matches any number of directories, a trailing slash only matches directories and the entries below a matched
+// directory are skipped as well. Segments are matched with path.Match
+func TarIgnore(patterns ...string) TarOpts {
+	return func(to *tarOpts) {
+		to.ignore = append(to.ignore, patterns...)
+	}
+}
+
+// TarInclude option to only add entries whose path relative to the tar root matches one of the patterns,
+// directories are still walked so patterns may match nested files
+func TarInclude(patterns ...string) TarOpts {
+	return func(to *tarOpts) {
+		to.include = append(to.include, patterns...)
+	}
+}
+
+// TarDirTime option to use a fixed modification time for directories, these change whenever an entry is added or removed
+func TarDirTime(t time.Time) TarOpts {
+	return func(to *tarOpts) {
+		to.dirTime = &t
+	}
+}
+
+// TarOwner option to record uid and gid as the owner of every entry instead of the owner on the host
+func TarOwner(uid, gid int) TarOpts {
+	return func(to *tarOpts) {
+		to.owner = &tarOwner{uid: uid, gid: gid}
+	}
+}
+
+// TarMode option to set the permissions of regular files and directories, 0 keeps the permissions on the host.
+// Files executable by their owner on the host stay executable for everyone fileMode lets read them
+func TarMode(fileMode, dirMode fs.FileMode) TarOpts {
+	return func(to *tarOpts) {
+		to.fileMode = fileMode.Perm()
+		to.dirMode = dirMode.Perm()
+	}
+}
+
+// TarReadOnly option to remove the write permissions of regular files
+func TarReadOnly(to *tarOpts) {
+	to.readOnly = true
+}
+
+// TarDereference option to add the files and directories symlinks point to instead of the symlinks
+func TarDereference(to *tarOpts) {
+	to.dereference = true
+}
+
+// TarLimits option to bound what Extract writes, see ExtractLimits
+func TarLimits(limits *ExtractLimits) TarOpts {
+	return func(to *tarOpts) {
+		to.limits = limits
+	}
+}
+
+// TarSameOwner option to give extracted entries the uid and gid recorded in the tar, this usually requires root
+func TarSameOwner(to *tarOpts) {
+	to.sameOwner = true
+}
+
+// TarWhiteouts option to add OCI whiteouts hiding the absolute paths of lower layers, they are written before any
+// other entry. Paths are not resolved, a whiteout below a symlink of a lower layer does not hide its target
+func TarWhiteouts(paths ...string) TarOpts {
+	return func(to *tarOpts) {
+		to.whiteouts = paths
+	}
+}
+
+// TarTrimPrefix option to only extract entries below prefix, with prefix removed from the name
+func TarTrimPrefix(prefix string) TarOpts {
+	return func(to *tarOpts) {
+		to.trimPrefix = prefix
+	}
 }
 
 // TODO: add option for full path or to adjust the relative path
@@ -41,7 +167,10 @@ func Tar(ctx context.Context, path string, w io.Writer, opts ...TarOpts) error {
 
 	twOut := w
 	if to.compress == "gzip" {
-		gw := gzip.NewWriter(w)
+		gw, err := NewCompressWriter(w, CompressGzip, CompressThreads(to.threads))
+		if err != nil {
+			return err
+		}
 		defer gw.Close()
 		twOut = gw
 	}
@@ -49,24 +178,43 @@ func Tar(ctx context.Context, path string, w io.Writer, opts ...TarOpts) error {
 	tw := tar.NewWriter(twOut)
 	defer tw.Close()
 
-	// walk the path performing a recursive tar
-	err := filepath.Walk(path, func(file string, fi os.FileInfo, err error) error {
-		// return any errors filepath encounters accessing the file
-		if err != nil {
+	// hard linked files are added once, later names become links to the first one
+	type linked struct {
+		name, relPath string
+	}
+	links := map[fileID]linked{}
+
+	for _, whiteout := range to.whiteouts {
+		if err := tw.WriteHeader(to.whiteoutHeader(whiteout)); err != nil {
 			return err
 		}
+	}
 
-		// TODO: handle symlinks, security attributes, hard links
-		// TODO: add options for file owner and timestamps
+	// walk the path performing a recursive tar
+	return to.walk(path, func(file, relPath string, fi os.FileInfo) error {
+		// TODO: handle security attributes
 		// TODO: add options to override time, or disable access/change stamps
 
-		// adjust for relative path
-		relPath, err := filepath.Rel(path, file)
-		if err != nil || relPath == "." {
-			return nil
+		var parts []FilePart
+		if to.files != nil && !fi.IsDir() {
+			var ok bool
+			parts, ok = to.files[filepath.ToSlash(relPath)]
+			if !ok {
+				return nil
+			}
+		}
+
+		link := ""
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			link = filepath.ToSlash(link)
 		}
 
-		header, err := tar.FileInfoHeader(fi, relPath)
+		header, err := tar.FileInfoHeader(fi, link)
 		if err != nil {
 			return err
 		}
@@ -77,11 +225,41 @@ func Tar(ctx context.Context, path string, w io.Writer, opts ...TarOpts) error {
 		header.AccessTime = time.Time{}
 		header.ChangeTime = time.Time{}
 		header.ModTime = header.ModTime.Truncate(time.Second)
+		if to.dirTime != nil && header.Typeflag == tar.TypeDir {
+			header.ModTime = *to.dirTime
+		}
+		header.Mode = header.Mode&^int64(fs.ModePerm) | int64(to.perm(fi.Mode()))
+		if to.owner != nil {
+			header.Uid, header.Gid = to.owner.uid, to.owner.gid
+			header.Uname, header.Gname = "", ""
+		}
+
+		if len(parts) > 0 {
+			return to.writeParts(tw, file, relPath, header, parts)
+		}
+
+		// dereferenced symlinks to the same file are stored as hard links as well
+		if id, nlink, ok := fileIdentity(fi); ok && header.Typeflag == tar.TypeReg && (nlink > 1 || to.dereference) {
+			if first, ok := links[id]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first.name
+				header.Size = 0
+				if to.digests != nil {
+					to.digests[filepath.ToSlash(relPath)] = to.digests[first.relPath]
+				}
+			} else {
+				links[id] = linked{name: header.Name, relPath: filepath.ToSlash(relPath)}
+			}
+		}
 
 		if err = tw.WriteHeader(header); err != nil {
 			return err
 		}
 
+		if to.digests != nil && header.Typeflag == tar.TypeReg && header.Size == 0 {
+			to.digests[filepath.ToSlash(relPath)] = digest.Canonical.FromBytes(nil)
+		}
+
 		// open file and copy contents into tar writer
 		if header.Typeflag == tar.TypeReg && header.Size > 0 {
 			//#nosec G304 filename is limited to provided path directory
@@ -89,9 +267,18 @@ func Tar(ctx context.Context, path string, w io.Writer, opts ...TarOpts) error {
 			if err != nil {
 				return err
 			}
-			if _, err = io.Copy(tw, f); err != nil {
+			var fw io.Writer = tw
+			var digester digest.Digester
+			if to.digests != nil {
+				digester = digest.Canonical.Digester()
+				fw = io.MultiWriter(tw, digester.Hash())
+			}
+			if _, err = io.Copy(fw, f); err != nil {
 				return err
 			}
+			if digester != nil {
+				to.digests[filepath.ToSlash(relPath)] = digester.Digest()
+			}
 			err = f.Close()
 			if err != nil {
 				return fmt.Errorf("failed to close file: %w", err)
@@ -99,7 +286,241 @@ func Tar(ctx context.Context, path string, w io.Writer, opts ...TarOpts) error {
 		}
 		return nil
 	})
-	return err
+}
+
+// whiteoutHeader returns the header of the whiteout removing name, see
+// https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+func (to tarOpts) whiteoutHeader(name string) *tar.Header {
+	name = path.Clean("/" + name)
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(path.Dir(name), WhiteoutPrefix+path.Base(name)),
+		Format:   tar.FormatPAX,
+		ModTime:  time.Unix(0, 0).UTC(),
+	}
+	if to.dirTime != nil {
+		header.ModTime = *to.dirTime
+	}
+	return header
+}
+
+// writeParts adds the parts of file as regular files of their own, header is the header of the whole file
+func (to tarOpts) writeParts(tw *tar.Writer, file, relPath string, header *tar.Header, parts []FilePart) error {
+	//#nosec G304 filename is limited to provided path directory
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := header.Name
+	for _, part := range parts {
+		partHeader := *header
+		partHeader.Name = PartName(name, part.Index)
+		partHeader.Size = part.Size
+		if err = tw.WriteHeader(&partHeader); err != nil {
+			return err
+		}
+
+		var fw io.Writer = tw
+		var digester digest.Digester
+		if to.digests != nil {
+			digester = digest.Canonical.Digester()
+			fw = io.MultiWriter(tw, digester.Hash())
+		}
+		if _, err = io.Copy(fw, io.NewSectionReader(f, part.Offset, part.Size)); err != nil {
+			return err
+		}
+		if digester != nil {
+			to.digests[PartName(filepath.ToSlash(relPath), part.Index)] = digester.Digest()
+		}
+	}
+
+	return nil
+}
+
+// ListEntry is an entry that Tar would add, Name is relative to the tar root
+type ListEntry struct {
+	Name string
+	Size int64
+	Mode fs.FileMode
+}
+
+// List returns the entries Tar would add for the same options without reading any file content
+func List(ctx context.Context, path string, opts ...TarOpts) (entries []ListEntry, err error) {
+	to := tarOpts{}
+	for _, opt := range opts {
+		opt(&to)
+	}
+
+	err = to.walk(path, func(_, relPath string, fi os.FileInfo) error {
+		entry := ListEntry{Name: filepath.ToSlash(relPath), Mode: fi.Mode()&^fs.ModePerm | to.perm(fi.Mode())}
+		if fi.Mode().IsRegular() {
+			entry.Size = fi.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return
+}
+
+// walk calls fn for every entry below root that is not filtered out by the exclude and include options.
+// With TarDereference symlinks are replaced by the file or directory they point to
+func (to tarOpts) walk(root string, fn func(file, relPath string, fi os.FileInfo) error) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	return to.walkDir(root, "", map[string]bool{realRoot: true}, fn)
+}
+
+// walkDir walks dir whose path relative to the tar root is prefix, visited holds the real path of the directories
+// being walked to detect symlink loops
+func (to tarOpts) walkDir(dir, prefix string, visited map[string]bool, fn func(file, relPath string, fi os.FileInfo) error) error {
+	return filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		// return any errors filepath encounters accessing the file
+		if err != nil {
+			return err
+		}
+
+		// adjust for relative path
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil || relPath == "." {
+			return nil
+		}
+		relPath = filepath.Join(prefix, relPath)
+
+		if to.excluded(filepath.ToSlash(relPath)) || to.ignored(filepath.ToSlash(relPath), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if to.dereference && fi.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Stat(file)
+			if err != nil {
+				return fmt.Errorf("failed to dereference %s: %w", file, err)
+			}
+
+			if target.IsDir() {
+				realDir, err := filepath.EvalSymlinks(file)
+				if err != nil {
+					return err
+				}
+				if visited[realDir] {
+					return fmt.Errorf("symlink loop at %s", file)
+				}
+
+				if to.included(filepath.ToSlash(relPath)) {
+					err = fn(file, relPath, target)
+					if err != nil {
+						return err
+					}
+				}
+
+				visited[realDir] = true
+				defer delete(visited, realDir)
+				return to.walkDir(realDir, relPath, visited, fn)
+			}
+
+			fi = target
+		}
+
+		if !to.included(filepath.ToSlash(relPath)) {
+			return nil
+		}
+
+		return fn(file, relPath, fi)
+	})
+}
+
+// perm returns the permissions an entry with mode on the host gets in the tar
+func (to tarOpts) perm(mode fs.FileMode) fs.FileMode {
+	perm := mode.Perm()
+	switch {
+	case mode.IsDir() && to.dirMode != 0:
+		perm = to.dirMode
+	case mode.IsRegular() && to.fileMode != 0:
+		executable := perm&0100 != 0
+		perm = to.fileMode
+		if executable {
+			perm |= (perm & 0444) >> 2
+		}
+	}
+
+	if to.readOnly && mode.IsRegular() {
+		perm &^= 0222
+	}
+
+	return perm
+}
+
+func (to tarOpts) excluded(name string) bool {
+	for _, pattern := range to.exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (to tarOpts) ignored(name string, isDir bool) bool {
+	for _, pattern := range to.ignore {
+		if IgnoreMatch(pattern, name, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoreMatch reports whether the slash separated relative name matches an ignore pattern, see TarIgnore
+func IgnoreMatch(pattern, name string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], name[0])
+	return matched && matchSegments(pattern[1:], name[1:])
+}
+
+func (to tarOpts) included(name string) bool {
+	if len(to.include) == 0 {
+		return true
+	}
+	for _, pattern := range to.include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
 }
 
 // Extract Tar
@@ -108,6 +529,10 @@ func Extract(ctx context.Context, path string, r io.Reader, opts ...TarOpts) err
 	for _, opt := range opts {
 		opt(&to)
 	}
+	limits := to.limits
+	if limits == nil {
+		limits = &ExtractLimits{}
+	}
 
 	// verify path exists
 	fi, err := os.Stat(path)
@@ -118,12 +543,25 @@ func Extract(ctx context.Context, path string, r io.Reader, opts ...TarOpts) err
 		return fmt.Errorf("extract path must be a directory: \"%s\"", path)
 	}
 
+	// symlinks are resolved against the real path when checking entries stay inside of path
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
 	// decompress
 	rd, err := Decompress(r)
 	if err != nil {
 		return err
 	}
 
+	// directory times change while entries are added, they are set once everything is extracted
+	type dirTime struct {
+		name    string
+		modTime time.Time
+	}
+	dirTimes := []dirTime{}
+
 	rt := tar.NewReader(rd)
 	for {
 		hdr, err := rt.Next()
@@ -133,20 +571,62 @@ func Extract(ctx context.Context, path string, r io.Reader, opts ...TarOpts) err
 		if err != nil {
 			return err
 		}
+
+		err = limits.addEntry(hdr)
+		if err != nil {
+			return err
+		}
+
+		if escapes(hdr.Name) {
+			return fmt.Errorf("%w: %s", ErrUnsafePath, hdr.Name)
+		}
+		name, ok := to.entryName(hdr.Name)
+		if !ok {
+			continue
+		}
 		// join a cleaned version of the filename with the path
-		fn := filepath.Join(path, filepath.Clean("/"+hdr.Name))
+		fn := filepath.Join(realPath, name)
+		if name == "/" {
+			continue
+		}
+		err = checkParent(realPath, fn)
+		if err != nil {
+			return err
+		}
+
+		//#nosec G115 tar header will hopefully not exceed fs.FileMode
+		perm := fs.FileMode(hdr.Mode).Perm()
 		switch hdr.Typeflag {
 		case tar.TypeDir:
-			// TODO: handle int overflows
-			//#nosec G115 tar header will hopefully not exceed fs.FileMode
-			err = os.MkdirAll(fn, fs.FileMode(hdr.Mode))
+			// an existing symlink or file is replaced, a symlink is never followed
+			if fi, err := os.Lstat(fn); err == nil && !fi.IsDir() {
+				err = removeExisting(fn)
+				if err != nil {
+					return err
+				}
+			}
+			err = os.Mkdir(fn, 0700)
+			if err != nil && !os.IsExist(err) {
+				return err
+			}
+			// directories stay accessible to the extracting user so later entries and layers can be added
+			err = os.Chmod(fn, perm|0700)
 			if err != nil {
 				return err
 			}
+			dirTimes = append(dirTimes, dirTime{name: fn, modTime: hdr.ModTime})
 		case tar.TypeReg:
-			// TODO: configure file mode, creation timestamp, etc
+			err = limits.addFile(hdr)
+			if err != nil {
+				return err
+			}
+			err = removeExisting(fn)
+			if err != nil {
+				return err
+			}
+			// setuid, setgid and sticky bits are dropped, the file is created writable and gets its mode once written
 			//#nosec G304 filename is limited to provided path directory
-			fh, err := os.Create(fn)
+			fh, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 			if err != nil {
 				return err
 			}
@@ -161,9 +641,232 @@ func Extract(ctx context.Context, path string, r io.Reader, opts ...TarOpts) err
 			if n != hdr.Size {
 				return fmt.Errorf("size mismatch extracting \"%s\", expected %d, extracted %d", hdr.Name, hdr.Size, n)
 			}
-			// TODO: handle other tar types (symlinks, etc)
+			err = os.Chmod(fn, perm)
+			if err != nil {
+				return err
+			}
+			err = os.Chtimes(fn, hdr.ModTime, hdr.ModTime)
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target, err := to.symlinkTarget(realPath, fn, name, hdr.Linkname)
+			if err != nil {
+				return err
+			}
+			err = removeExisting(fn)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(target, fn)
+			if err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkName, ok := to.entryName(hdr.Linkname)
+			if !ok || escapes(hdr.Linkname) {
+				return fmt.Errorf("%w: hard link %s to %s", ErrUnsafeLink, hdr.Name, hdr.Linkname)
+			}
+			src := filepath.Join(realPath, linkName)
+			err = checkParent(realPath, src)
+			if err != nil {
+				return err
+			}
+			// a hard link to a symlink would copy a relative target into another directory
+			fi, err := os.Lstat(src)
+			if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return fmt.Errorf("%w: hard link %s to %s is not a regular file", ErrUnsafeLink, hdr.Name, hdr.Linkname)
+			}
+			err = removeExisting(fn)
+			if err != nil {
+				return err
+			}
+			err = os.Link(src, fn)
+			if err != nil {
+				return err
+			}
+		default:
+			// TODO: handle other tar types (devices, fifos, etc)
+			continue
+		}
+
+		if to.sameOwner {
+			err = os.Lchown(fn, hdr.Uid, hdr.Gid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		err = os.Chtimes(dirTimes[i].name, dirTimes[i].modTime, dirTimes[i].modTime)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// escapes reports whether a relative entry or link name leaves the directory it is extracted to
+func escapes(name string) bool {
+	cleaned := path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+	return cleaned == ".." || strings.HasPrefix(cleaned, "../")
+}
+
+// entryName returns the cleaned absolute name of a tar entry below the trimmed prefix, ok is false outside of it
+func (to tarOpts) entryName(hdrName string) (name string, ok bool) {
+	name = filepath.Clean("/" + hdrName)
+	if to.trimPrefix == "" {
+		return name, true
+	}
+
+	prefix := filepath.Clean("/" + to.trimPrefix)
+	rel, err := filepath.Rel(prefix, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return "/" + rel, true
+}
+
+// symlinkTarget returns the target of a symlink named name extracted to fn, an absolute target below the trimmed
+// prefix is made relative to the link. Targets that resolve outside of root are refused, the symlinks extracted
+// earlier are followed from the real directory of the link
+func (to tarOpts) symlinkTarget(root, fn, name, linkname string) (string, error) {
+	target := filepath.FromSlash(linkname)
+	if filepath.IsAbs(target) {
+		targetName, ok := to.entryName(linkname)
+		if !ok || to.trimPrefix == "" {
+			return "", fmt.Errorf("%w: symlink %s to %s", ErrUnsafeLink, name, linkname)
+		}
+		rel, err := filepath.Rel(filepath.Dir(name), targetName)
+		if err != nil {
+			return "", err
+		}
+		target = rel
+	}
+
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(fn))
+	if err != nil {
+		return "", err
+	}
+	resolved, err := resolveLink(realParent, target)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("%w: symlink %s to %s resolves to %s", ErrUnsafeLink, name, linkname, resolved)
+	}
+	return target, nil
+}
+
+// resolveLink returns the path the relative target resolves to from dir, following the symlinks that already exist.
+// Components that do not exist yet are resolved lexically
+func resolveLink(dir, target string) (string, error) {
+	current := dir
+	for _, component := range strings.Split(target, string(filepath.Separator)) {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, component)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		real, err := filepath.EvalSymlinks(next)
+		if os.IsNotExist(err) {
+			current = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		current = real
+	}
+
+	return current, nil
+}
+
+// checkParent creates the missing directories of file one at a time, every existing component has to resolve to a
+// path inside of root before anything is created below it, symlinks extracted earlier could otherwise redirect the
+// entry outside of root
+func checkParent(root, file string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(file))
+	if err != nil || !within(root, filepath.Dir(file)) {
+		return fmt.Errorf("%w: %s is outside of %s", ErrUnsafeLink, file, root)
+	}
+	if rel == "." {
+		return nil
+	}
+
+	current := root
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		next := filepath.Join(current, component)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			// entries are not required to be preceded by their directories
+			err = os.Mkdir(next, 0755)
+			if err != nil {
+				return err
+			}
+			current = next
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			real, err := filepath.EvalSymlinks(next)
+			if err != nil {
+				return fmt.Errorf("%w: %s of %s cannot be resolved: %v", ErrUnsafeLink, next, file, err)
+			}
+			if !within(root, real) {
+				return fmt.Errorf("%w: %s resolves to %s", ErrUnsafeLink, file, real)
+			}
+			next = real
+		}
+
+		fi, err = os.Stat(next)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("parent %s of %s is not a directory", next, file)
+		}
+		current = next
+	}
+
+	return nil
+}
+
+// within reports whether the cleaned path name is root or below it
+func within(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeExisting removes a file or symlink at name so the entry replaces it instead of writing through a symlink
+func removeExisting(name string) error {
+	fi, err := os.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cannot replace directory %s", name)
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,496 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestTarExtractTrimPrefix(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	files := map[string]string{
+		"model.om":            "weights",
+		"sub/method_infer.md": "# infer",
+		"..data/model.bin":    "dotted",
+	}
+	for name, content := range files {
+		fn := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	digests := map[string]digest.Digest{}
+	if err := Tar(ctx, srcDir, buf, TarCompressGzip, TarDigests(digests)); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+	for name, content := range files {
+		if digests[name] != digest.FromString(content) {
+			t.Errorf("digest mismatch for %s: expected %s, received %s", name, digest.FromString(content), digests[name])
+		}
+	}
+
+	t.Run("trimmed", func(t *testing.T) {
+		outDir := t.TempDir()
+		if err := Extract(ctx, outDir, bytes.NewReader(buf.Bytes()), TarTrimPrefix(srcDir)); err != nil {
+			t.Fatalf("failed to extract: %v", err)
+		}
+		for name, content := range files {
+			out, err := os.ReadFile(filepath.Join(outDir, name))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", name, err)
+			}
+			if string(out) != content {
+				t.Errorf("content mismatch for %s: expected %s, received %s", name, content, out)
+			}
+		}
+	})
+
+	t.Run("outside prefix", func(t *testing.T) {
+		outDir := t.TempDir()
+		if err := Extract(ctx, outDir, bytes.NewReader(buf.Bytes()), TarTrimPrefix(filepath.Join(srcDir, "sub"))); err != nil {
+			t.Fatalf("failed to extract: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "model.om")); err == nil {
+			t.Errorf("file outside of prefix was extracted")
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "method_infer.md")); err != nil {
+			t.Errorf("file below prefix was not extracted: %v", err)
+		}
+	})
+}
+
+func TestTarExclude(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	for _, name := range []string{"model.om", ".modelmesh/server.yaml", ".modelmesh/workspace.lock", "cache/blob"} {
+		fn := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fn, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	digests := map[string]digest.Digest{}
+	if err := Tar(ctx, srcDir, io.Discard, TarDigests(digests), TarExclude(".modelmesh/*.lock", "cache")); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+	if len(digests) != 2 || digests["model.om"] == "" || digests[".modelmesh/server.yaml"] == "" {
+		t.Errorf("unexpected files in tar: %v", digests)
+	}
+
+	digests = map[string]digest.Digest{}
+	if err := Tar(ctx, srcDir, io.Discard, TarDigests(digests), TarInclude("model.om", ".modelmesh/*.lock"), TarExclude("cache")); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+	if len(digests) != 2 || digests["model.om"] == "" || digests[".modelmesh/workspace.lock"] == "" {
+		t.Errorf("unexpected files in tar: %v", digests)
+	}
+
+	entries, err := List(ctx, srcDir, TarExclude(".modelmesh/*.lock", "cache"))
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != ".modelmesh,.modelmesh/server.yaml,model.om" || entries[2].Size != int64(len("model.om")) {
+		t.Errorf("unexpected list entries: %v", entries)
+	}
+}
+
+func TestIgnoreMatch(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		expect  bool
+	}{
+		{pattern: "*.tmp", name: "a.tmp", expect: true},
+		{pattern: "*.tmp", name: "model/v1/a.tmp", expect: true},
+		{pattern: "*.tmp", name: "model/a.tmp.bin"},
+		{pattern: "cache", name: "model/cache", isDir: true, expect: true},
+		{pattern: "cache/", name: "model/cache", isDir: true, expect: true},
+		{pattern: "cache/", name: "model/cache"},
+		{pattern: "model/*.bak", name: "model/a.bak", expect: true},
+		{pattern: "model/*.bak", name: "v2/model/a.bak"},
+		{pattern: "/a.tmp", name: "a.tmp", expect: true},
+		{pattern: "/a.tmp", name: "model/a.tmp"},
+		{pattern: "model/**/*.bak", name: "model/a.bak", expect: true},
+		{pattern: "model/**/*.bak", name: "model/v1/old/a.bak", expect: true},
+		{pattern: "**/checkpoints", name: "model/v1/checkpoints", isDir: true, expect: true},
+	}
+
+	for _, tt := range tests {
+		if received := IgnoreMatch(tt.pattern, tt.name, tt.isDir); received != tt.expect {
+			t.Errorf("%s on %s: expected %t, received %t", tt.pattern, tt.name, tt.expect, received)
+		}
+	}
+}
+
+func TestTarIgnore(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	for _, name := range []string{"model.om", "a.tmp", "v1/b.tmp", "v1/model.om", "v1/checkpoints/c.om", "checkpoints.md"} {
+		fn := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(fn, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	entries, err := List(ctx, srcDir, TarIgnore("*.tmp", "checkpoints/"))
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != "checkpoints.md,model.om,v1,v1/model.om" {
+		t.Errorf("unexpected list entries: %v", names)
+	}
+}
+
+func TestTarOwnerMode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "model"), 0700); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "model", "model.om"), []byte("weights"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "start.sh"), []byte("#!/bin/bash"), 0700); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Tar(ctx, srcDir, buf, TarOwner(1000, 2000), TarMode(0644, 0755), TarReadOnly); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+
+	expect := map[string]int64{"model": 0755, "model/model.om": 0444, "start.sh": 0555}
+	rt := tar.NewReader(buf)
+	for {
+		hdr, err := rt.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+
+		name, _ := filepath.Rel(srcDir, hdr.Name)
+		if hdr.Mode != expect[name] {
+			t.Errorf("expected mode %o for %s, received %o", expect[name], name, hdr.Mode)
+		}
+		if hdr.Uid != 1000 || hdr.Gid != 2000 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("unexpected owner of %s: %d:%d %s:%s", name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+	}
+
+	entries, err := List(ctx, srcDir, TarMode(0644, 0755), TarReadOnly)
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+	for _, entry := range entries {
+		if int64(entry.Mode.Perm()) != expect[entry.Name] {
+			t.Errorf("expected listed mode %o for %s, received %o", expect[entry.Name], entry.Name, entry.Mode.Perm())
+		}
+	}
+}
+
+func TestTarLinks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(srcDir, "shared"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "v1"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "shared", "weights.bin"), []byte("weights"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Symlink("../shared/weights.bin", filepath.Join(srcDir, "v1", "weights.bin")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Link(filepath.Join(srcDir, "shared", "weights.bin"), filepath.Join(srcDir, "weights.bin")); err != nil {
+		t.Fatalf("failed to create hard link: %v", err)
+	}
+
+	types := func(opts ...TarOpts) (*bytes.Buffer, map[string]byte) {
+		buf := &bytes.Buffer{}
+		if err := Tar(ctx, srcDir, buf, opts...); err != nil {
+			t.Fatalf("failed to tar: %v", err)
+		}
+		entries := map[string]byte{}
+		rt := tar.NewReader(bytes.NewReader(buf.Bytes()))
+		for {
+			hdr, err := rt.Next()
+			if err == io.EOF {
+				return buf, entries
+			}
+			if err != nil {
+				t.Fatalf("failed to read tar: %v", err)
+			}
+			name, _ := filepath.Rel(srcDir, hdr.Name)
+			entries[name] = hdr.Typeflag
+		}
+	}
+
+	buf, entries := types()
+	if entries["v1/weights.bin"] != tar.TypeSymlink || entries["shared/weights.bin"] != tar.TypeReg || entries["weights.bin"] != tar.TypeLink {
+		t.Errorf("unexpected entry types: %v", entries)
+	}
+
+	outDir := t.TempDir()
+	if err := Extract(ctx, outDir, bytes.NewReader(buf.Bytes()), TarTrimPrefix(srcDir)); err != nil {
+		t.Fatalf("failed to extract: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(outDir, "v1", "weights.bin")); err != nil || target != "../shared/weights.bin" {
+		t.Errorf("symlink not restored: %s, %v", target, err)
+	}
+	shared, errShared := os.Stat(filepath.Join(outDir, "shared", "weights.bin"))
+	linked, errLinked := os.Stat(filepath.Join(outDir, "weights.bin"))
+	if errShared != nil || errLinked != nil || !os.SameFile(shared, linked) {
+		t.Errorf("hard link not restored: %v, %v", errShared, errLinked)
+	}
+
+	_, entries = types(TarDereference)
+	if entries["v1/weights.bin"] == tar.TypeSymlink {
+		t.Errorf("symlink not dereferenced: %v", entries)
+	}
+}
+
+func TestExtractUnsafeLinks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		entries []tar.Header
+	}{
+		{
+			name:    "relative symlink",
+			entries: []tar.Header{{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tar.Header{{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		},
+		{
+			name: "file through symlinked parent",
+			entries: []tar.Header{
+				{Name: "self", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "self/.."},
+				{Name: "up/escaped", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name:    "hard link",
+			entries: []tar.Header{{Name: "escape", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+		},
+		{
+			name: "hard link to relative symlink",
+			entries: []tar.Header{
+				{Name: "sub/l", Typeflag: tar.TypeSymlink, Linkname: "../x"},
+				{Name: "l2", Typeflag: tar.TypeLink, Linkname: "sub/l"},
+			},
+		},
+		{
+			name: "chained symlinks",
+			entries: []tar.Header{
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "a/b/s", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+				{Name: "a/b/s/l", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+				{Name: "l/created/f", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name: "directory through dangling symlink",
+			entries: []tar.Header{
+				{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "missing"},
+				{Name: "missing", Typeflag: tar.TypeSymlink, Linkname: "s/../../outside"},
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "d/created/f", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			for _, hdr := range tt.entries {
+				hdr := hdr
+				if err := tw.WriteHeader(&hdr); err != nil {
+					t.Fatalf("failed to write header: %v", err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatalf("failed to close tar: %v", err)
+			}
+
+			outDir := filepath.Join(t.TempDir(), "out")
+			if err := os.Mkdir(outDir, 0755); err != nil {
+				t.Fatalf("failed to create dir: %v", err)
+			}
+			err := Extract(ctx, outDir, buf)
+			if !errors.Is(err, ErrUnsafeLink) {
+				t.Errorf("expected ErrUnsafeLink, received %v", err)
+			}
+			for _, name := range []string{"escaped", "outside"} {
+				if _, err := os.Lstat(filepath.Join(outDir, "..", name)); err == nil {
+					t.Errorf("%s written outside of the extract directory", name)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := tw.WriteHeader(&tar.Header{Name: "model/" + name, Typeflag: tar.TypeReg, Mode: 04755, Size: 4, ModTime: modTime}); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte("1234")); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		limits ExtractLimits
+		expect error
+	}{
+		{name: "entries", limits: ExtractLimits{MaxEntries: 2}, expect: ErrEntryLimit},
+		{name: "file size", limits: ExtractLimits{MaxFileSize: 3}, expect: ErrFileSizeLimit},
+		{name: "total size", limits: ExtractLimits{MaxTotalSize: 10}, expect: ErrTotalSizeLimit},
+		{name: "unsafe path", limits: ExtractLimits{MaxTotalSize: 12}, expect: ErrUnsafePath},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := Extract(ctx, t.TempDir(), bytes.NewReader(buf.Bytes()), TarLimits(&tt.limits))
+			if !errors.Is(err, tt.expect) {
+				t.Fatalf("expected %v, received %v", tt.expect, err)
+			}
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) && limitErr.Limit == 0 {
+				t.Errorf("limit missing in %v", err)
+			}
+		})
+	}
+
+	t.Run("accumulated", func(t *testing.T) {
+		t.Parallel()
+		limits := &ExtractLimits{MaxTotalSize: 16}
+		layer := &bytes.Buffer{}
+		tw := tar.NewWriter(layer)
+		if err := tw.WriteHeader(&tar.Header{Name: "weights", Typeflag: tar.TypeReg, Mode: 0644, Size: 10}); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatalf("failed to close tar: %v", err)
+		}
+
+		outDir := t.TempDir()
+		if err := Extract(ctx, outDir, bytes.NewReader(layer.Bytes()), TarLimits(limits)); err != nil {
+			t.Fatalf("failed to extract first layer: %v", err)
+		}
+		if err := Extract(ctx, outDir, bytes.NewReader(layer.Bytes()), TarLimits(limits)); !errors.Is(err, ErrTotalSizeLimit) {
+			t.Errorf("expected the second layer to exceed the total size, received %v", err)
+		}
+	})
+
+	t.Run("modes", func(t *testing.T) {
+		t.Parallel()
+		outDir := t.TempDir()
+		err := Extract(ctx, outDir, bytes.NewReader(buf.Bytes()), TarTrimPrefix("model"))
+		if !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("expected %v, received %v", ErrUnsafePath, err)
+		}
+		fi, err := os.Stat(filepath.Join(outDir, "a"))
+		if err != nil {
+			t.Fatalf("failed to stat: %v", err)
+		}
+		if fi.Mode() != 0755 || !fi.ModTime().Equal(modTime) {
+			t.Errorf("unexpected mode %v or time %v", fi.Mode(), fi.ModTime())
+		}
+	})
+}
+
+func TestTarWhiteouts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "model.om"), []byte("weights"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := Tar(ctx, srcDir, buf, TarWhiteouts("/opt/samples/", "/demo")); err != nil {
+		t.Fatalf("failed to tar: %v", err)
+	}
+
+	names := []string{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+
+	expect := []string{"/opt/.wh.samples", "/.wh.demo", filepath.ToSlash(filepath.Join(srcDir, "model.om"))}
+	if strings.Join(names, ",") != strings.Join(expect, ",") {
+		t.Errorf("expected entries %v, received %v", expect, names)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package archive
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID identifies a file independent of its names
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileIdentity returns the id and link count of a file
+func fileIdentity(fi fs.FileInfo) (id fileID, nlink uint64, ok bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	//#nosec G115 device numbers are not negative
+	return fileID{dev: uint64(stat.Dev), ino: stat.Ino}, uint64(stat.Nlink), true
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package archive
+
+import (
+	"io/fs"
+)
+
+// fileID identifies a file independent of its names
+type fileID struct {
+	dev, ino uint64
+}
+
+// fileIdentity returns the id and link count of a file, files are not identified on windows
+func fileIdentity(fi fs.FileInfo) (id fileID, nlink uint64, ok bool) {
+	return
+}
@@ -0,0 +1,60 @@
+package sbom
+
+import (
+	"bytes"
+	"context"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/ref"
+)
+
+// PushReferrer pushes the SBOM content as an OCI artifact referring to the subject manifest of r
+func PushReferrer(ctx context.Context, rc *regclient.RegClient, r ref.Ref, subject descriptor.Descriptor, content []byte) (dig digest.Digest, err error) {
+	configDesc := descriptor.Descriptor{
+		MediaType: mediatype.OCI1Empty,
+		Digest:    descriptor.EmptyDigest,
+		Size:      int64(len(descriptor.EmptyData)),
+	}
+
+	_, err = rc.BlobPut(ctx, r, configDesc, bytes.NewReader(descriptor.EmptyData))
+	if err != nil {
+		return
+	}
+
+	layerDesc := descriptor.Descriptor{
+		MediaType: MediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+
+	_, err = rc.BlobPut(ctx, r, layerDesc, bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+
+	subject = descriptor.Descriptor{
+		MediaType: subject.MediaType,
+		Digest:    subject.Digest,
+		Size:      subject.Size,
+	}
+
+	m, err := manifest.New(manifest.WithOrig(v1.Manifest{
+		Versioned:    v1.ManifestSchemaVersion,
+		MediaType:    mediatype.OCI1Manifest,
+		ArtifactType: MediaType,
+		Config:       configDesc,
+		Layers:       []descriptor.Descriptor{layerDesc},
+		Subject:      &subject,
+	}))
+	if err != nil {
+		return
+	}
+
+	dig = m.GetDescriptor().Digest
+	err = rc.ManifestPut(ctx, r.SetDigest(dig.String()), m)
+	return
+}
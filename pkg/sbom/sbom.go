@@ -0,0 +1,232 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MediaType is the artifact type of CycloneDX JSON documents
+	MediaType = "application/vnd.cyclonedx+json"
+	// FileName is the name the SBOM is written under in the workspace meta dir
+	FileName = "sbom.cdx.json"
+
+	specVersion = "1.5"
+
+	KindModel    = "model"
+	KindScript   = "script"
+	KindDoc      = "documentation"
+	KindMetadata = "metadata"
+
+	kindProperty = "packctl:kind"
+	baseImageRef = "base-image"
+	modelRef     = "model"
+)
+
+// BOM is a CycloneDX document
+type BOM struct {
+	BOMFormat    string       `json:"bomFormat"`
+	SpecVersion  string       `json:"specVersion"`
+	SerialNumber string       `json:"serialNumber"`
+	Version      int          `json:"version"`
+	Metadata     Metadata     `json:"metadata"`
+	Components   []Component  `json:"components"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Metadata describes the document and the model image it is about
+type Metadata struct {
+	Timestamp string    `json:"timestamp"`
+	Tools     Tools     `json:"tools"`
+	Component Component `json:"component"`
+}
+
+// Tools lists the tools that created the document
+type Tools struct {
+	Components []Component `json:"components"`
+}
+
+// Component is an item of the inventory
+type Component struct {
+	Type       string     `json:"type"`
+	BOMRef     string     `json:"bom-ref,omitempty"`
+	Name       string     `json:"name"`
+	Version    string     `json:"version,omitempty"`
+	PURL       string     `json:"purl,omitempty"`
+	Hashes     []Hash     `json:"hashes,omitempty"`
+	Licenses   []License  `json:"licenses,omitempty"`
+	Properties []Property `json:"properties,omitempty"`
+}
+
+// Hash is a checksum of a component
+type Hash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// License is a license declared for a component
+type License struct {
+	License LicenseName `json:"license"`
+}
+
+// LicenseName names a license
+type LicenseName struct {
+	Name string `json:"name"`
+}
+
+// Property is a name value pair attached to a component
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Dependency records what a component depends on
+type Dependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// Model describes the model image the SBOM is generated for
+type Model struct {
+	Name              string
+	Version           string
+	License           string
+	ServableLicenses  map[string]string
+	Image             string
+	BaseImage         string
+	BaseDigest        digest.Digest
+	Files             map[string]digest.Digest
+	ToolVersion       string
+	MethodFilePrefix  string
+	MetadataDirectory string
+}
+
+// New creates the CycloneDX document of a model image
+func New(m Model) (bom BOM, err error) {
+	serial, err := newUUID()
+	if err != nil {
+		return
+	}
+
+	modelComponent := Component{
+		Type:     "machine-learning-model",
+		BOMRef:   modelRef,
+		Name:     m.Name,
+		Version:  m.Version,
+		Licenses: licenses(m.License),
+	}
+	if m.Image != "" {
+		modelComponent.Properties = []Property{{Name: "packctl:image", Value: m.Image}}
+	}
+
+	bom = BOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  specVersion,
+		SerialNumber: "urn:uuid:" + serial,
+		Version:      1,
+		Metadata: Metadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools: Tools{
+				Components: []Component{
+					{Type: "application", Name: "packctl", Version: m.ToolVersion},
+				},
+			},
+			Component: modelComponent,
+		},
+		Components: []Component{
+			{
+				Type:    "container",
+				BOMRef:  baseImageRef,
+				Name:    m.BaseImage,
+				Version: m.BaseDigest.String(),
+				Hashes:  hashes(m.BaseDigest),
+			},
+		},
+	}
+
+	dependsOn := []string{baseImageRef}
+	fileNames := []string{}
+	for name := range m.Files {
+		fileNames = append(fileNames, name)
+	}
+
+	sort.Strings(fileNames)
+	for _, name := range fileNames {
+		kind := m.fileKind(name)
+		component := Component{
+			Type:       "file",
+			BOMRef:     "file:" + name,
+			Name:       name,
+			Hashes:     hashes(m.Files[name]),
+			Properties: []Property{{Name: kindProperty, Value: kind}},
+		}
+
+		servable := strings.SplitN(name, "/", 2)[0]
+		if license, ok := m.ServableLicenses[servable]; ok && strings.Contains(name, "/") {
+			component.Licenses = licenses(license)
+		}
+
+		bom.Components = append(bom.Components, component)
+		dependsOn = append(dependsOn, component.BOMRef)
+	}
+
+	bom.Dependencies = []Dependency{
+		{Ref: modelRef, DependsOn: dependsOn},
+		{Ref: baseImageRef, DependsOn: []string{}},
+	}
+	return
+}
+
+// Marshal encodes the document as indented JSON
+func (bom BOM) Marshal() ([]byte, error) {
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+func (m Model) fileKind(name string) string {
+	base := path.Base(name)
+	switch {
+	case strings.HasPrefix(name, m.MetadataDirectory+"/"), path.Ext(base) == ".yaml" && !strings.Contains(name, "/"):
+		return KindMetadata
+	case m.MethodFilePrefix != "" && strings.HasPrefix(base, m.MethodFilePrefix), path.Ext(base) == ".md", path.Ext(base) == ".txt":
+		return KindDoc
+	case path.Ext(base) == ".py", path.Ext(base) == ".sh":
+		return KindScript
+	default:
+		return KindModel
+	}
+}
+
+func hashes(d digest.Digest) []Hash {
+	if d == "" || d.Algorithm() != digest.SHA256 {
+		return nil
+	}
+
+	return []Hash{{Alg: "SHA-256", Content: d.Encoded()}}
+}
+
+func licenses(license string) []License {
+	if license == "" {
+		return nil
+	}
+
+	return []License{{License: LicenseName{Name: license}}}
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,62 @@
+package sbom
+
+import (
+	"github.com/opencontainers/go-digest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	m := Model{
+		Name:             "demo",
+		Version:          "v1",
+		License:          "Apache-2.0",
+		ServableLicenses: map[string]string{"resnet": "MIT"},
+		BaseImage:        "registry.example.org/base:v1",
+		BaseDigest:       digest.FromString("base"),
+		Files: map[string]digest.Digest{
+			".modelmesh/server.yaml":   digest.FromString("server"),
+			"resnet/model.om":          digest.FromString("weights"),
+			"resnet/method_predict.md": digest.FromString("# predict"),
+			"serving_server.py":        digest.FromString("print()"),
+			"bert/model.om":            digest.FromString("bert"),
+		},
+		MethodFilePrefix:  "method_",
+		MetadataDirectory: ".modelmesh",
+	}
+
+	bom, err := New(m)
+	if err != nil {
+		t.Fatalf("failed to create sbom: %v", err)
+	}
+
+	if len(bom.Components) != len(m.Files)+1 {
+		t.Fatalf("expected %d components, received %d", len(m.Files)+1, len(bom.Components))
+	}
+	if bom.Components[0].Type != "container" || bom.Components[0].Hashes[0].Content != m.BaseDigest.Encoded() {
+		t.Errorf("unexpected base image component: %v", bom.Components[0])
+	}
+	if len(bom.Metadata.Component.Licenses) != 1 || bom.Metadata.Component.Licenses[0].License.Name != "Apache-2.0" {
+		t.Errorf("unexpected model licenses: %v", bom.Metadata.Component.Licenses)
+	}
+
+	expectKinds := map[string]string{
+		".modelmesh/server.yaml":   KindMetadata,
+		"resnet/model.om":          KindModel,
+		"resnet/method_predict.md": KindDoc,
+		"serving_server.py":        KindScript,
+		"bert/model.om":            KindModel,
+	}
+	for _, c := range bom.Components[1:] {
+		if c.Properties[0].Value != expectKinds[c.Name] {
+			t.Errorf("unexpected kind of %s: expected %s, received %s", c.Name, expectKinds[c.Name], c.Properties[0].Value)
+		}
+		if c.Hashes[0].Content != m.Files[c.Name].Encoded() {
+			t.Errorf("unexpected hash of %s", c.Name)
+		}
+
+		hasLicense := len(c.Licenses) == 1 && c.Licenses[0].License.Name == "MIT"
+		if hasLicense != (c.Name == "resnet/model.om" || c.Name == "resnet/method_predict.md") {
+			t.Errorf("unexpected licenses of %s: %v", c.Name, c.Licenses)
+		}
+	}
+}
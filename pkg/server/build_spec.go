@@ -0,0 +1,379 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/constants"
+	"github.com/edgewize-io/image-packaging-tool/pkg/utils"
+	"github.com/regclient/regclient/types/platform"
+	"github.com/regclient/regclient/types/ref"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuildSpec declares the inputs of a build, it is read from packctl.yaml or the build section of server.yaml.
+// Flags given to packctl build override the values of the spec
+type BuildSpec struct {
+	BaseImage      string            `yaml:"baseImage,omitempty"`
+	Target         string            `yaml:"target,omitempty"`
+	Platforms      string            `yaml:"platforms,omitempty"`
+	DeviceType     string            `yaml:"deviceType,omitempty"`
+	Tags           []string          `yaml:"tags,omitempty"`
+	Labels         map[string]string `yaml:"labels,omitempty"`
+	Env            map[string]string `yaml:"env,omitempty"`
+	Ports          []string          `yaml:"ports,omitempty"`
+	WorkingDir     string            `yaml:"workdir,omitempty"`
+	User           string            `yaml:"user,omitempty"`
+	StopSignal     string            `yaml:"stopSignal,omitempty"`
+	Cmd            []string          `yaml:"cmd,omitempty"`
+	EntrypointMode string            `yaml:"entrypointMode,omitempty"`
+	Owner          string            `yaml:"owner,omitempty"`
+	FileMode       string            `yaml:"fileMode,omitempty"`
+	DirMode        string            `yaml:"dirMode,omitempty"`
+	ReadOnly       bool              `yaml:"readOnly,omitempty"`
+	Dereference    bool              `yaml:"dereference,omitempty"`
+	MaxLayerSize   string            `yaml:"maxLayerSize,omitempty"`
+	RemovePaths    []string          `yaml:"removePaths,omitempty"`
+	Squash         bool              `yaml:"squash,omitempty"`
+	Ignore         []string          `yaml:"ignore,omitempty"`
+}
+
+// SpecError lists every problem found in a build spec
+type SpecError struct {
+	File     string
+	Problems []string
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("invalid build spec %s:\n  %s", e.File, strings.Join(e.Problems, "\n  "))
+}
+
+// ReadBuildSpec loads a packctl.yaml, all problems of a malformed spec are returned together as a *SpecError
+func ReadBuildSpec(specFilePath string) (spec *BuildSpec, err error) {
+	root, err := readYAMLNode(specFilePath)
+	if err != nil {
+		return
+	}
+
+	if root == nil {
+		return &BuildSpec{}, nil
+	}
+
+	return decodeBuildSpec(specFilePath, root)
+}
+
+// ReadServerBuildSpec loads the build section of a server.yaml, it returns nil when there is none
+func ReadServerBuildSpec(serverFilePath string) (spec *BuildSpec, err error) {
+	root, err := readYAMLNode(serverFilePath)
+	if err != nil || root == nil || root.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "build" {
+			return decodeBuildSpec(serverFilePath+" build", root.Content[i+1])
+		}
+	}
+
+	return
+}
+
+func readYAMLNode(filePath string) (root *yaml.Node, err error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+
+	doc := &yaml.Node{}
+	err = yaml.NewDecoder(bytes.NewReader(content)).Decode(doc)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &SpecError{File: filePath, Problems: []string{err.Error()}}
+	}
+
+	return doc.Content[0], nil
+}
+
+func decodeBuildSpec(name string, node *yaml.Node) (spec *BuildSpec, err error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, &SpecError{File: name, Problems: []string{fmt.Sprintf("line %d: build spec must be a mapping", node.Line)}}
+	}
+
+	known := map[string]bool{}
+	specType := reflect.TypeOf(BuildSpec{})
+	for i := 0; i < specType.NumField(); i++ {
+		fieldName, _, _ := strings.Cut(specType.Field(i).Tag.Get("yaml"), ",")
+		known[fieldName] = true
+	}
+
+	problems := []string{}
+	lines := map[string]int{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		lines[key.Value] = key.Line
+		if !known[key.Value] {
+			problems = append(problems, fmt.Sprintf("line %d: unknown field %s", key.Line, key.Value))
+		}
+	}
+
+	spec = &BuildSpec{}
+	var typeErr *yaml.TypeError
+	err = node.Decode(spec)
+	if errors.As(err, &typeErr) {
+		problems = append(problems, typeErr.Errors...)
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	for _, problem := range spec.validate() {
+		problems = append(problems, fmt.Sprintf("line %d: %s", lines[problem.field], problem.message))
+	}
+
+	if len(problems) > 0 {
+		return nil, &SpecError{File: name, Problems: problems}
+	}
+
+	return spec, nil
+}
+
+type specProblem struct {
+	field   string
+	message string
+}
+
+func (spec *BuildSpec) validate() (problems []specProblem) {
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, specProblem{field: field, message: field + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	if spec.BaseImage != "" {
+		if _, err := ref.New(spec.BaseImage); err != nil {
+			add("baseImage", "invalid image %s: %v", spec.BaseImage, err)
+		}
+	}
+
+	if spec.Platforms != "" {
+		if _, err := platform.Parse(spec.Platforms); err != nil {
+			add("platforms", "invalid platform %s: %v", spec.Platforms, err)
+		}
+	}
+
+	if spec.DeviceType != "" {
+		supported := false
+		for _, deviceType := range constants.DeviceTypes {
+			supported = supported || strings.EqualFold(deviceType, spec.DeviceType)
+		}
+		if !supported {
+			add("deviceType", "unknown device type %s, support: %s", spec.DeviceType, strings.Join(constants.DeviceTypes, ", "))
+		}
+	}
+
+	for i, tag := range spec.Tags {
+		if strings.TrimSpace(tag) == "" {
+			add("tags", "tag %d is empty", i+1)
+		}
+	}
+
+	for _, name := range sortedKeys(spec.Labels) {
+		if name == "" {
+			add("labels", "label name is empty")
+		}
+	}
+
+	for _, name := range sortedKeys(spec.Env) {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			add("env", "invalid variable name %q", name)
+		}
+	}
+
+	for _, port := range spec.Ports {
+		if _, err := NormalizePort(port); err != nil {
+			add("ports", "%v", err)
+		}
+	}
+
+	if spec.WorkingDir != "" && !path.IsAbs(spec.WorkingDir) {
+		add("workdir", "working directory %s must be absolute", spec.WorkingDir)
+	}
+
+	if strings.ContainsAny(spec.User, " \t") {
+		add("user", "invalid user %q, use user[:group] or uid[:gid]", spec.User)
+	}
+
+	if spec.StopSignal != "" {
+		if err := ValidateStopSignal(spec.StopSignal); err != nil {
+			add("stopSignal", "%v", err)
+		}
+	}
+
+	if spec.EntrypointMode != "" {
+		if err := ValidateEntrypointMode(spec.EntrypointMode); err != nil {
+			add("entrypointMode", "%v", err)
+		}
+	}
+
+	if spec.Owner != "" {
+		if _, _, err := ParseOwner(spec.Owner); err != nil {
+			add("owner", "%v", err)
+		}
+	}
+
+	if spec.FileMode != "" {
+		if _, err := ParseFileMode(spec.FileMode); err != nil {
+			add("fileMode", "%v", err)
+		}
+	}
+
+	if spec.DirMode != "" {
+		if _, err := ParseFileMode(spec.DirMode); err != nil {
+			add("dirMode", "%v", err)
+		}
+	}
+
+	for _, pattern := range spec.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			add("ignore", "invalid pattern %q, see https://pkg.go.dev/path#Match", pattern)
+		}
+	}
+
+	if spec.MaxLayerSize != "" {
+		if _, err := ParseMaxLayerSize(spec.MaxLayerSize); err != nil {
+			add("maxLayerSize", "%v", err)
+		}
+	}
+
+	for _, removePath := range spec.RemovePaths {
+		if err := ValidateRemovePath(removePath); err != nil {
+			add("removePaths", "%v", err)
+		}
+	}
+
+	return
+}
+
+// EnvList returns the env of the spec as sorted KEY=value entries
+func (spec *BuildSpec) EnvList() []string {
+	env := []string{}
+	for _, name := range sortedKeys(spec.Env) {
+		env = append(env, name+"="+spec.Env[name])
+	}
+
+	return env
+}
+
+// NormalizePort returns port as an exposed port of the image config, e.g. 8080 is 8080/tcp
+func NormalizePort(port string) (string, error) {
+	number, protocol, found := strings.Cut(port, "/")
+	if !found {
+		protocol = "tcp"
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q, use port[/protocol] with a port between 1 and 65535", port)
+	}
+
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid protocol of port %q, support: tcp, udp, sctp", port)
+	}
+
+	return strconv.Itoa(n) + "/" + protocol, nil
+}
+
+// ValidateStopSignal checks the stop signal is a signal name such as SIGTERM or a signal number
+func ValidateStopSignal(signal string) error {
+	if n, err := strconv.Atoi(signal); err == nil {
+		if n < 1 || n > 64 {
+			return fmt.Errorf("invalid stop signal %s, signal numbers are between 1 and 64", signal)
+		}
+		return nil
+	}
+
+	name, found := strings.CutPrefix(signal, "SIG")
+	if !found || name == "" || strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+-") != "" {
+		return fmt.Errorf("invalid stop signal %s, use a signal name such as SIGTERM or a signal number", signal)
+	}
+
+	return nil
+}
+
+// ValidateEntrypointMode checks mode is one of the supported entrypoint modes
+func ValidateEntrypointMode(mode string) error {
+	for _, supported := range constants.EntrypointModes {
+		if mode == supported {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown entrypoint mode %s, support: %s", mode, strings.Join(constants.EntrypointModes, ", "))
+}
+
+// ParseOwner parses a numeric uid:gid, the gid defaults to the uid
+func ParseOwner(owner string) (uid, gid int, err error) {
+	uidStr, gidStr, found := strings.Cut(owner, ":")
+	if !found {
+		gidStr = uidStr
+	}
+
+	uid, errUID := strconv.Atoi(uidStr)
+	gid, errGID := strconv.Atoi(gidStr)
+	if errUID != nil || errGID != nil || uid < 0 || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %q, use a numeric uid[:gid]", owner)
+	}
+
+	return uid, gid, nil
+}
+
+// ValidateRemovePath checks a base image path to remove is absolute and is neither the root nor a whiteout
+func ValidateRemovePath(removePath string) error {
+	cleaned := path.Clean(removePath)
+	if !path.IsAbs(removePath) || cleaned == "/" || strings.HasPrefix(path.Base(cleaned), ".wh.") {
+		return fmt.Errorf("invalid path to remove %s, it must be absolute and not /", removePath)
+	}
+	return nil
+}
+
+// MinLayerSize is the smallest size layers may be limited to, smaller layers would mostly hold tar headers
+const MinLayerSize = 1 << 20
+
+// ParseMaxLayerSize parses a layer size limit such as 2GiB, 0 means layers are not limited
+func ParseMaxLayerSize(size string) (int64, error) {
+	maxSize, err := utils.ParseSize(size)
+	if err != nil {
+		return 0, err
+	}
+	if maxSize != 0 && maxSize < MinLayerSize {
+		return 0, fmt.Errorf("layer size %s is smaller than 1MiB", size)
+	}
+	return maxSize, nil
+}
+
+// ParseFileMode parses octal permissions such as 0644
+func ParseFileMode(mode string) (fs.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm == 0 || perm > 0777 {
+		return 0, fmt.Errorf("invalid mode %q, use octal permissions such as 0644", mode)
+	}
+
+	return fs.FileMode(perm), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadBuildSpec(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name           string
+		content        string
+		expectProblems []string
+	}{
+		{
+			name: "valid",
+			content: `baseImage: registry.example.org/mindspore/serving:2.0
+target: registry.example.org/models/resnet:{{.Version}}
+platforms: linux/arm64
+deviceType: ascend
+tags: [latest]
+labels:
+  org.opencontainers.image.vendor: edgewize
+env:
+  MODEL_THREADS: "4"
+ports: ["8080", 9090/udp]
+workdir: /opt/model
+user: "1000:1000"
+stopSignal: SIGINT
+cmd: [--port, "8080"]
+entrypointMode: keep
+owner: "1000:1000"
+fileMode: "0644"
+dirMode: "0755"
+readOnly: true
+dereference: true
+maxLayerSize: 2GiB
+removePaths: [/opt/samples]
+squash: true
+ignore: ["*.ckpt", data]
+`,
+		},
+		{name: "empty"},
+		{
+			name: "malformed",
+			content: `baseimage: registry.example.org/base:1
+deviceType: TPU
+tags: latest
+env:
+  "BAD NAME": x
+ports: [http]
+workdir: opt/model
+stopSignal: TERM
+owner: nobody
+ignore: ["[a-"]
+maxLayerSize: 10k
+removePaths: [/, opt/samples]
+`,
+			expectProblems: []string{
+				"line 1: unknown field baseimage",
+				"line 3: cannot unmarshal !!str `latest` into []string",
+				"line 2: deviceType: unknown device type TPU",
+				`line 4: env: invalid variable name "BAD NAME"`,
+				`line 6: ports: invalid port "http"`,
+				"line 7: workdir: working directory opt/model must be absolute",
+				"line 8: stopSignal: invalid stop signal TERM",
+				`line 9: owner: invalid owner "nobody"`,
+				`line 10: ignore: invalid pattern "[a-"`,
+				"line 11: maxLayerSize: layer size 10k is smaller than 1MiB",
+				"line 12: removePaths: invalid path to remove /, it must be absolute and not /",
+				"line 12: removePaths: invalid path to remove opt/samples, it must be absolute and not /",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			specFilePath := filepath.Join(t.TempDir(), "packctl.yaml")
+			if err := os.WriteFile(specFilePath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write spec: %v", err)
+			}
+
+			spec, err := ReadBuildSpec(specFilePath)
+			if len(tt.expectProblems) == 0 {
+				if err != nil {
+					t.Fatalf("failed to read spec: %v", err)
+				}
+				if spec == nil {
+					t.Fatalf("spec is nil")
+				}
+				return
+			}
+
+			var specErr *SpecError
+			if !errors.As(err, &specErr) {
+				t.Fatalf("expected a spec error, received %v", err)
+			}
+			if len(specErr.Problems) != len(tt.expectProblems) {
+				t.Fatalf("expected %d problems, received %v", len(tt.expectProblems), specErr.Problems)
+			}
+			for i, expect := range tt.expectProblems {
+				if !strings.Contains(specErr.Problems[i], expect) {
+					t.Errorf("expected problem %q, received %q", expect, specErr.Problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReadServerBuildSpec(t *testing.T) {
+	t.Parallel()
+	serverFilePath := filepath.Join(t.TempDir(), "server.yaml")
+	content := `name: resnet
+version: v1
+build:
+  baseImage: registry.example.org/base:1
+  env:
+    A: b
+`
+	if err := os.WriteFile(serverFilePath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write server.yaml: %v", err)
+	}
+
+	spec, err := ReadServerBuildSpec(serverFilePath)
+	if err != nil {
+		t.Fatalf("failed to read build section: %v", err)
+	}
+	if spec == nil || spec.BaseImage != "registry.example.org/base:1" || strings.Join(spec.EnvList(), ",") != "A=b" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	serverFile, err := ReadServerFile(serverFilePath)
+	if err != nil || serverFile.Build == nil {
+		t.Errorf("build section not kept in server file: %v", err)
+	}
+}
+
+func TestNormalizePort(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		port      string
+		expect    string
+		expectErr bool
+	}{
+		{port: "8080", expect: "8080/tcp"},
+		{port: "53/UDP", expect: "53/udp"},
+		{port: "0", expectErr: true},
+		{port: "70000", expectErr: true},
+		{port: "8080/http", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		port, err := NormalizePort(tt.port)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("expected an error for %s, received %s", tt.port, port)
+			}
+			continue
+		}
+		if err != nil || port != tt.expect {
+			t.Errorf("expected %s for %s, received %s, %v", tt.expect, tt.port, port, err)
+		}
+	}
+}
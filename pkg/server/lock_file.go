@@ -0,0 +1,68 @@
+package server
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"time"
+)
+
+// LockFile pins the inputs of a build, it is stored as .modelmesh/packctl.lock
+type LockFile struct {
+	Base BaseImageLock `yaml:"base"`
+	// Matrix pins the base image of every device type of a matrix build, keyed by the lowercase device type
+	Matrix map[string]BaseImageLock `yaml:"matrix,omitempty"`
+}
+
+type BaseImageLock struct {
+	Image      string    `yaml:"image"`
+	Digest     string    `yaml:"digest"`
+	ResolvedAt time.Time `yaml:"resolvedAt"`
+	// Shell is where the start shell resolved to in the pinned base image, empty until checked
+	Shell string `yaml:"shell,omitempty"`
+}
+
+// GetBase returns the base image pinned for a matrix device type, or the base image when deviceType is empty
+func (lf *LockFile) GetBase(deviceType string) (BaseImageLock, bool) {
+	if deviceType == "" {
+		return lf.Base, lf.Base.Image != ""
+	}
+
+	base, ok := lf.Matrix[strings.ToLower(deviceType)]
+	return base, ok
+}
+
+// SetBase pins the base image for a matrix device type, or the base image when deviceType is empty
+func (lf *LockFile) SetBase(deviceType string, base BaseImageLock) {
+	if deviceType == "" {
+		lf.Base = base
+		return
+	}
+
+	if lf.Matrix == nil {
+		lf.Matrix = map[string]BaseImageLock{}
+	}
+	lf.Matrix[strings.ToLower(deviceType)] = base
+}
+
+// ReadLockFile loads the lock file, the returned error satisfies os.IsNotExist when there is none
+func ReadLockFile(lockFilePath string) (lockFile *LockFile, err error) {
+	content, err := os.ReadFile(lockFilePath)
+	if err != nil {
+		return
+	}
+
+	lockFile = &LockFile{}
+	err = yaml.Unmarshal(content, lockFile)
+	return
+}
+
+// WriteLockFile stores the lock file
+func WriteLockFile(lockFilePath string, lockFile *LockFile) (err error) {
+	content, err := yaml.Marshal(lockFile)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(lockFilePath, content, 0644)
+}
@@ -1,11 +1,19 @@
 package server
 
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
 type ServerFile struct {
 	Name        string           `yaml:"name"`
 	Version     string           `yaml:"version"`
 	Description string           `yaml:"description"`
+	License     string           `yaml:"license,omitempty"`
 	Servables   []ServableConfig `yaml:"servables"`
 	Image       ImageInfo        `yaml:"image"`
+	BaseImage   *BaseImageInfo   `yaml:"baseImage,omitempty"`
+	Build       *BuildSpec       `yaml:"build,omitempty"`
 }
 
 type ServableConfig struct {
@@ -13,6 +21,7 @@ type ServableConfig struct {
 	Description string         `yaml:"description,omitempty"`
 	ModelFile   string         `yaml:"modelFile,omitempty"`
 	ModelFormat string         `yaml:"modelFormat,omitempty"`
+	License     string         `yaml:"license,omitempty"`
 	Methods     []MethodDetail `yaml:"methods"`
 }
 
@@ -27,3 +36,20 @@ type ImageInfo struct {
 	Repository string `yaml:"repository"`
 	Tag        string `yaml:"tag"`
 }
+
+type BaseImageInfo struct {
+	Image  string `yaml:"image"`
+	Digest string `yaml:"digest"`
+}
+
+// ReadServerFile loads a server.yaml
+func ReadServerFile(serverFilePath string) (serverFile *ServerFile, err error) {
+	content, err := os.ReadFile(serverFilePath)
+	if err != nil {
+		return
+	}
+
+	serverFile = &ServerFile{}
+	err = yaml.Unmarshal(content, serverFile)
+	return
+}
@@ -0,0 +1,184 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/edgewize-io/image-packaging-tool/pkg/ratelimit"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+)
+
+const (
+	// DSSEMediaType is the layer media type of cosign attestations
+	DSSEMediaType = "application/vnd.dsse.envelope.v1+json"
+	// InTotoPayloadType is the DSSE payload type of in-toto statements
+	InTotoPayloadType = "application/vnd.in-toto+json"
+	// PredicateTypeAnnotation holds the predicate type of an attestation layer
+	PredicateTypeAnnotation = "predicateType"
+)
+
+// Envelope is a DSSE envelope wrapping an attestation statement
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is a signature of a DSSE envelope
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Attestation is an attestation pushed for an image
+type Attestation struct {
+	PredicateType string
+	Envelope      Envelope
+}
+
+// AttestationTag returns the tag cosign stores the attestations of a manifest digest under
+func AttestationTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.att", d.Algorithm().String(), d.Encoded())
+}
+
+// NewEnvelope wraps an in-toto statement, the envelope is left unsigned when signer is nil
+func NewEnvelope(statement []byte, signer crypto.Signer) (env Envelope, err error) {
+	env = Envelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(statement),
+		Signatures:  []EnvelopeSignature{},
+	}
+
+	if signer == nil {
+		return
+	}
+
+	sig, err := Sign(signer, pae(env.PayloadType, statement))
+	if err != nil {
+		return
+	}
+
+	env.Signatures = append(env.Signatures, EnvelopeSignature{
+		Sig: base64.StdEncoding.EncodeToString(sig),
+	})
+	return
+}
+
+// Statement returns the decoded payload of the envelope
+func (env Envelope) Statement() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(env.Payload)
+}
+
+// Verify checks that at least one signature of the envelope is valid for the public key
+func (env Envelope) Verify(pub crypto.PublicKey) (err error) {
+	if len(env.Signatures) == 0 {
+		return fmt.Errorf("attestation is not signed")
+	}
+
+	statement, err := env.Statement()
+	if err != nil {
+		return
+	}
+
+	for _, envSig := range env.Signatures {
+		sig, _err := base64.StdEncoding.DecodeString(envSig.Sig)
+		if _err != nil {
+			err = _err
+			continue
+		}
+
+		err = Verify(pub, pae(env.PayloadType, statement), sig)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return
+}
+
+// AttachAttestation pushes an attestation for the manifest digest dig of r,
+// replacing any earlier attestation with the same predicate type
+func AttachAttestation(ctx context.Context, rc *regclient.RegClient, r ref.Ref, dig digest.Digest, predicateType string, env Envelope) (err error) {
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		return
+	}
+
+	rAtt := r.SetTag(AttestationTag(dig))
+	existing, err := existingLayers(ctx, rc, rAtt, DSSEMediaType)
+	if err != nil {
+		return
+	}
+
+	layers := []descriptor.Descriptor{}
+	for _, layer := range existing {
+		if layer.Annotations[PredicateTypeAnnotation] != predicateType {
+			layers = append(layers, layer)
+		}
+	}
+
+	envDesc, err := putBlob(ctx, rc, rAtt, DSSEMediaType, envBytes)
+	if err != nil {
+		return
+	}
+
+	envDesc.Annotations = map[string]string{
+		PredicateTypeAnnotation: predicateType,
+	}
+
+	layers = append(layers, envDesc)
+	return putLayersManifest(ctx, rc, rAtt, layers)
+}
+
+// GetAttestations returns the attestations pushed for the manifest digest dig of r, read through limiter
+func GetAttestations(ctx context.Context, rc *regclient.RegClient, r ref.Ref, dig digest.Digest, limiter *ratelimit.Limiter) (attestations []Attestation, err error) {
+	attestations = []Attestation{}
+	rAtt := r.SetTag(AttestationTag(dig))
+	layers, err := existingLayers(ctx, rc, rAtt, DSSEMediaType)
+	if err != nil {
+		return
+	}
+
+	for _, layer := range layers {
+		env, _err := readEnvelope(ctx, rc, rAtt, layer, limiter)
+		if _err != nil {
+			err = fmt.Errorf("failed to read attestation %s: %w", layer.Digest, _err)
+			return
+		}
+
+		attestations = append(attestations, Attestation{
+			PredicateType: layer.Annotations[PredicateTypeAnnotation],
+			Envelope:      env,
+		})
+	}
+
+	return
+}
+
+func readEnvelope(ctx context.Context, rc *regclient.RegClient, r ref.Ref, layer descriptor.Descriptor, limiter *ratelimit.Limiter) (env Envelope, err error) {
+	rdr, err := rc.BlobGet(ctx, r, layer)
+	if err != nil {
+		return
+	}
+
+	defer rdr.Close()
+
+	envBytes, err := io.ReadAll(io.LimitReader(limiter.Reader(ctx, rdr), maxPayloadSize))
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(envBytes, &env)
+	return
+}
+
+// pae is the DSSE pre-authentication encoding that signatures are computed over
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
@@ -0,0 +1,45 @@
+package signature
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	t.Parallel()
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`)
+	signer, err := GenerateKey(KeyTypeECDSA)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	otherSigner, err := GenerateKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	env, err := NewEnvelope(statement, signer)
+	if err != nil {
+		t.Fatalf("failed to create envelope: %v", err)
+	}
+	out, err := env.Statement()
+	if err != nil {
+		t.Fatalf("failed to decode statement: %v", err)
+	}
+	if !bytes.Equal(out, statement) {
+		t.Errorf("statement mismatch: expected %s, received %s", statement, out)
+	}
+	if err := env.Verify(signer.Public()); err != nil {
+		t.Errorf("failed to verify: %v", err)
+	}
+	if err := env.Verify(otherSigner.Public()); err == nil {
+		t.Errorf("verify succeeded with the wrong key")
+	}
+
+	unsigned, err := NewEnvelope(statement, nil)
+	if err != nil {
+		t.Fatalf("failed to create unsigned envelope: %v", err)
+	}
+	if err := unsigned.Verify(signer.Public()); err == nil {
+		t.Errorf("verify succeeded on an unsigned envelope")
+	}
+}
@@ -0,0 +1,253 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"github.com/regclient/regclient"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/errs"
+	"github.com/regclient/regclient/types/manifest"
+	"github.com/regclient/regclient/types/mediatype"
+	v1 "github.com/regclient/regclient/types/oci/v1"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"strings"
+)
+
+const (
+	// SimpleSigningMediaType is the layer media type of cosign signatures
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation holds the base64 encoded signature of a layer payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// maxPayloadSize limits how much of a signature payload is read
+	maxPayloadSize = 1 << 20
+)
+
+// SignatureTag returns the tag cosign stores the signatures of a manifest digest under
+func SignatureTag(d digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", d.Algorithm().String(), d.Encoded())
+}
+
+// Repository returns the docker reference identity used in signature payloads, registry aliases such as
+// index.docker.io resolve to the same name
+func Repository(r ref.Ref) string {
+	return normalizeRepository(r.Registry + "/" + r.Repository)
+}
+
+// normalizeRepository resolves registry aliases and the implicit library namespace the way regclient parses refs,
+// names that do not parse are returned as is
+func normalizeRepository(name string) string {
+	r, err := ref.New(strings.ToLower(name))
+	if err != nil {
+		return name
+	}
+
+	return r.Registry + "/" + r.Repository
+}
+
+// SignImage signs the manifest r resolves to and pushes the signature to the image repository
+func SignImage(ctx context.Context, rc *regclient.RegClient, r ref.Ref, signer crypto.Signer) (dig digest.Digest, err error) {
+	m, err := rc.ManifestHead(ctx, r, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	dig = m.GetDescriptor().Digest
+	payload, err := NewPayload(Repository(r), dig.String())
+	if err != nil {
+		return
+	}
+
+	sig, err := Sign(signer, payload)
+	if err != nil {
+		return
+	}
+
+	rSig := r.SetTag(SignatureTag(dig))
+	layers, err := existingLayers(ctx, rc, rSig, SimpleSigningMediaType)
+	if err != nil {
+		return
+	}
+
+	payloadDesc, err := putBlob(ctx, rc, rSig, SimpleSigningMediaType, payload)
+	if err != nil {
+		return
+	}
+
+	payloadDesc.Annotations = map[string]string{
+		SignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+	}
+
+	for _, layer := range layers {
+		if layer.Digest == payloadDesc.Digest && layer.Annotations[SignatureAnnotation] == payloadDesc.Annotations[SignatureAnnotation] {
+			return
+		}
+	}
+
+	layers = append(layers, payloadDesc)
+	err = putLayersManifest(ctx, rc, rSig, layers)
+	return
+}
+
+// VerifyImage checks the signatures of the manifest r resolves to against the public key
+// and returns the verified digest with the number of valid signatures
+func VerifyImage(ctx context.Context, rc *regclient.RegClient, r ref.Ref, pub crypto.PublicKey) (dig digest.Digest, valid int, err error) {
+	m, err := rc.ManifestHead(ctx, r, regclient.WithManifestRequireDigest())
+	if err != nil {
+		return
+	}
+
+	dig = m.GetDescriptor().Digest
+	rSig := r.SetTag(SignatureTag(dig))
+	layers, err := existingLayers(ctx, rc, rSig, SimpleSigningMediaType)
+	if err != nil {
+		return
+	}
+
+	if len(layers) == 0 {
+		err = fmt.Errorf("no signatures found for %s@%s", r.CommonName(), dig)
+		return
+	}
+
+	errList := []error{}
+	for _, layer := range layers {
+		_err := verifyLayer(ctx, rc, rSig, layer, pub, Repository(r), dig)
+		if _err != nil {
+			errList = append(errList, _err)
+			continue
+		}
+		valid++
+	}
+
+	if valid == 0 {
+		err = fmt.Errorf("no valid signature for %s@%s: %w", r.CommonName(), dig, errors.Join(errList...))
+	}
+
+	return
+}
+
+func verifyLayer(ctx context.Context, rc *regclient.RegClient, rSig ref.Ref, layer descriptor.Descriptor, pub crypto.PublicKey, repository string, dig digest.Digest) (err error) {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[SignatureAnnotation])
+	if err != nil {
+		return fmt.Errorf("failed to decode signature of layer %s: %w", layer.Digest, err)
+	}
+
+	rdr, err := rc.BlobGet(ctx, rSig, layer)
+	if err != nil {
+		return
+	}
+
+	defer rdr.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(rdr, maxPayloadSize))
+	if err != nil {
+		return
+	}
+
+	err = Verify(pub, payload, sig)
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", layer.Digest, err)
+	}
+
+	ss, err := ParsePayload(payload)
+	if err != nil {
+		return
+	}
+
+	err = ss.Check(repository, dig.String())
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", layer.Digest, err)
+	}
+
+	return nil
+}
+
+// existingLayers returns the layers of mediaType already pushed to the signature or attestation tag rSig
+func existingLayers(ctx context.Context, rc *regclient.RegClient, rSig ref.Ref, mediaType string) (layers []descriptor.Descriptor, err error) {
+	layers = []descriptor.Descriptor{}
+	m, err := rc.ManifestGet(ctx, rSig)
+	if err != nil {
+		if errors.Is(err, errs.ErrNotFound) {
+			err = nil
+		}
+		return
+	}
+
+	mi, ok := m.(manifest.Imager)
+	if !ok {
+		err = fmt.Errorf("unexpected signature manifest type %s", m.GetDescriptor().MediaType)
+		return
+	}
+
+	allLayers, err := mi.GetLayers()
+	if err != nil {
+		return
+	}
+
+	for _, layer := range allLayers {
+		if layer.MediaType == mediaType {
+			layers = append(layers, layer)
+		}
+	}
+
+	return
+}
+
+// putLayersManifest pushes a manifest holding layers to the signature or attestation tag rSig
+func putLayersManifest(ctx context.Context, rc *regclient.RegClient, rSig ref.Ref, layers []descriptor.Descriptor) (err error) {
+	diffIDs := []digest.Digest{}
+	for _, layer := range layers {
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+
+	config := v1.Image{
+		RootFS: v1.RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return
+	}
+
+	configDesc, err := putBlob(ctx, rc, rSig, mediatype.OCI1ImageConfig, configBytes)
+	if err != nil {
+		return
+	}
+
+	m, err := manifest.New(manifest.WithOrig(v1.Manifest{
+		Versioned: v1.ManifestSchemaVersion,
+		MediaType: mediatype.OCI1Manifest,
+		Config:    configDesc,
+		Layers:    layers,
+	}))
+	if err != nil {
+		return
+	}
+
+	return rc.ManifestPut(ctx, rSig, m)
+}
+
+func putBlob(ctx context.Context, rc *regclient.RegClient, r ref.Ref, mediaType string, content []byte) (descriptor.Descriptor, error) {
+	desc := descriptor.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+
+	_, err := rc.BlobPut(ctx, r, desc, bytes.NewReader(content))
+	if err != nil {
+		return descriptor.Descriptor{}, err
+	}
+
+	return desc, nil
+}
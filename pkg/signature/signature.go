@@ -0,0 +1,201 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+
+	privateKeyPEMType = "PRIVATE KEY"
+	publicKeyPEMType  = "PUBLIC KEY"
+
+	// simpleSigningType is the critical type cosign writes into the signed payload
+	simpleSigningType = "cosign container image signature"
+)
+
+// SimpleSigning is the payload signed for an image, compatible with cosign
+type SimpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// NewPayload creates the payload to sign for the manifest digest of repository
+func NewPayload(repository, manifestDigest string) ([]byte, error) {
+	ss := SimpleSigning{}
+	ss.Critical.Identity.DockerReference = repository
+	ss.Critical.Image.DockerManifestDigest = manifestDigest
+	ss.Critical.Type = simpleSigningType
+	return json.Marshal(ss)
+}
+
+// ParsePayload parses a signed payload and checks its type
+func ParsePayload(payload []byte) (ss SimpleSigning, err error) {
+	err = json.Unmarshal(payload, &ss)
+	if err != nil {
+		return
+	}
+
+	if ss.Critical.Type != simpleSigningType {
+		err = fmt.Errorf("unknown signature payload type %q", ss.Critical.Type)
+	}
+
+	return
+}
+
+// Check checks that the payload signs the manifest digest of repository
+func (ss SimpleSigning) Check(repository, manifestDigest string) error {
+	if normalizeRepository(ss.Critical.Identity.DockerReference) != normalizeRepository(repository) {
+		return fmt.Errorf("payload signs repository %s, expected %s", ss.Critical.Identity.DockerReference, repository)
+	}
+
+	if ss.Critical.Image.DockerManifestDigest != manifestDigest {
+		return fmt.Errorf("payload signs digest %s, expected %s", ss.Critical.Image.DockerManifestDigest, manifestDigest)
+	}
+
+	return nil
+}
+
+// GenerateKey creates a new private key of keyType
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case KeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return nil, fmt.Errorf("unsupported key type [%s], support: [\"%s\", \"%s\"]", keyType, KeyTypeECDSA, KeyTypeEd25519)
+	}
+}
+
+// WriteKeyPair writes the private key and its public key as PEM files
+func WriteKeyPair(signer crypto.Signer, privateKeyPath, publicKeyPath string) (err error) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: privateKeyPEMType, Bytes: privDER}), 0600)
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: publicKeyPEMType, Bytes: pubDER}), 0644)
+}
+
+// LoadPrivateKey reads a PKCS8 PEM private key
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path, privateKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T in %s", key, path)
+	}
+}
+
+// LoadPublicKey reads a PKIX PEM public key
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path, publicKeyPEMType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return k, nil
+	case ed25519.PublicKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T in %s", key, path)
+	}
+}
+
+// Sign signs payload the way cosign does: ECDSA over the SHA256 digest, ed25519 over the raw payload
+func Sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	switch k := signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.SignASN1(rand.Reader, k, digest[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, payload), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", signer)
+	}
+}
+
+// Verify checks sig of payload against the public key
+func Verify(pub crypto.PublicKey, payload, sig []byte) error {
+	valid := false
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		valid = ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, payload, sig)
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	if !valid {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+func readPEM(path, pemType string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != pemType {
+		return nil, fmt.Errorf("no %s PEM block found in %s", pemType, path)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,128 @@
+package signature
+
+import (
+	"github.com/regclient/regclient/types/ref"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	t.Parallel()
+	payload, err := NewPayload("registry.example.org/models/resnet", "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatalf("failed to create payload: %v", err)
+	}
+	for _, keyType := range []string{KeyTypeECDSA, KeyTypeEd25519} {
+		keyType := keyType
+		t.Run(keyType, func(t *testing.T) {
+			t.Parallel()
+			signer, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatalf("failed to generate key: %v", err)
+			}
+			dir := t.TempDir()
+			privPath := filepath.Join(dir, "test.key")
+			pubPath := filepath.Join(dir, "test.pub")
+			if err := WriteKeyPair(signer, privPath, pubPath); err != nil {
+				t.Fatalf("failed to write key pair: %v", err)
+			}
+			loadedSigner, err := LoadPrivateKey(privPath)
+			if err != nil {
+				t.Fatalf("failed to load private key: %v", err)
+			}
+			pub, err := LoadPublicKey(pubPath)
+			if err != nil {
+				t.Fatalf("failed to load public key: %v", err)
+			}
+			sig, err := Sign(loadedSigner, payload)
+			if err != nil {
+				t.Fatalf("failed to sign: %v", err)
+			}
+			if err := Verify(pub, payload, sig); err != nil {
+				t.Errorf("failed to verify: %v", err)
+			}
+			if err := Verify(pub, append(payload, ' '), sig); err == nil {
+				t.Errorf("verify succeeded on a modified payload")
+			}
+		})
+	}
+}
+
+func TestParsePayload(t *testing.T) {
+	t.Parallel()
+	payload, err := NewPayload("registry.example.org/models/resnet", "sha256:abc")
+	if err != nil {
+		t.Fatalf("failed to create payload: %v", err)
+	}
+	ss, err := ParsePayload(payload)
+	if err != nil {
+		t.Fatalf("failed to parse payload: %v", err)
+	}
+	if ss.Critical.Image.DockerManifestDigest != "sha256:abc" {
+		t.Errorf("unexpected digest %s", ss.Critical.Image.DockerManifestDigest)
+	}
+	if _, err := ParsePayload([]byte(`{"critical":{"type":"other"}}`)); err == nil {
+		t.Errorf("parse succeeded on an unknown payload type")
+	}
+}
+
+func TestPayloadCheck(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		signed     string
+		repository string
+		digest     string
+		wantErr    bool
+	}{
+		{name: "match", signed: "registry.example.org/models/resnet", repository: "registry.example.org/models/resnet", digest: "sha256:abc"},
+		{name: "registry alias", signed: "index.docker.io/library/resnet", repository: "docker.io/library/resnet", digest: "sha256:abc"},
+		{name: "library namespace", signed: "docker.io/resnet", repository: "registry-1.docker.io/library/resnet", digest: "sha256:abc"},
+		{name: "registry case", signed: "Registry.Example.org/models/resnet", repository: "registry.example.org/models/resnet", digest: "sha256:abc"},
+		{name: "other repository", signed: "registry.example.org/models/resnet", repository: "registry.example.org/models/vgg", digest: "sha256:abc", wantErr: true},
+		{name: "other registry", signed: "registry.example.org/models/resnet", repository: "mirror.example.org/models/resnet", digest: "sha256:abc", wantErr: true},
+		{name: "other namespace", signed: "docker.io/models/resnet", repository: "docker.io/library/resnet", digest: "sha256:abc", wantErr: true},
+		{name: "other digest", signed: "registry.example.org/models/resnet", repository: "registry.example.org/models/resnet", digest: "sha256:def", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			payload, err := NewPayload(tt.signed, "sha256:abc")
+			if err != nil {
+				t.Fatalf("failed to create payload: %v", err)
+			}
+			ss, err := ParsePayload(payload)
+			if err != nil {
+				t.Fatalf("failed to parse payload: %v", err)
+			}
+			err = ss.Check(tt.repository, tt.digest)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRepository(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		image  string
+		expect string
+	}{
+		{image: "resnet", expect: "docker.io/library/resnet"},
+		{image: "docker.io/resnet:v1", expect: "docker.io/library/resnet"},
+		{image: "index.docker.io/library/resnet", expect: "docker.io/library/resnet"},
+		{image: "registry-1.docker.io/models/resnet@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", expect: "docker.io/models/resnet"},
+		{image: "registry.example.org:5000/models/resnet:v1", expect: "registry.example.org:5000/models/resnet"},
+	}
+	for _, tt := range tests {
+		r, err := ref.New(tt.image)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", tt.image, err)
+		}
+		if received := Repository(r); received != tt.expect {
+			t.Errorf("%s: expected %s, received %s", tt.image, tt.expect, received)
+		}
+	}
+}
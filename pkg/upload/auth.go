@@ -0,0 +1,145 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	regConfig "github.com/regclient/regclient/config"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// auth answers the registry authentication challenges for a single repository
+type auth struct {
+	host   *regConfig.Host
+	scope  string
+	header string
+}
+
+// challenge parses a WWW-Authenticate header and prepares the Authorization header for the next request
+func (a *auth) challenge(ctx context.Context, client *http.Client, userAgent string, wwwAuth string) (err error) {
+	scheme, params := parseChallenge(wwwAuth)
+	cred := a.host.GetCred()
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if cred.User == "" && cred.Password == "" {
+			return fmt.Errorf("registry %s requires credentials, run packctl login", a.host.Name)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(cred.User, cred.Password)
+		a.header = req.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		token, err := a.token(ctx, client, userAgent, params, cred)
+		if err != nil {
+			return err
+		}
+
+		a.header = "Bearer " + token
+		return nil
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q from registry %s", scheme, a.host.Name)
+	}
+}
+
+func (a *auth) token(ctx context.Context, client *http.Client, userAgent string, params map[string]string, cred regConfig.Cred) (token string, err error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q from registry %s", params["realm"], a.host.Name)
+	}
+
+	form := url.Values{}
+	if params["service"] != "" {
+		form.Set("service", params["service"])
+	}
+	form.Set("scope", a.scope)
+	// the registry names the scope it expects, it differs from ours when a mirror maps the repository
+	if params["scope"] != "" && params["scope"] != a.scope {
+		form.Add("scope", params["scope"])
+	}
+
+	var req *http.Request
+	if cred.Token != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", cred.Token)
+		form.Set("client_id", "packctl")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		realm.RawQuery = form.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return
+		}
+		if cred.User != "" || cred.Password != "" {
+			req.SetBasicAuth(cred.User, cred.Password)
+		}
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get token from %s: %s", realm.Host, resp.Status)
+	}
+
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return
+	}
+
+	token = tokenResp.Token
+	if token == "" {
+		token = tokenResp.AccessToken
+	}
+
+	if token == "" {
+		err = fmt.Errorf("empty token from %s", realm.Host)
+	}
+
+	return
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into the scheme and its parameters
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = map[string]string{}
+	header = strings.TrimSpace(header)
+	scheme, rest, _ := strings.Cut(header, " ")
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			params[key] = value
+		}
+	}
+
+	return
+}
@@ -0,0 +1,486 @@
+package upload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	regConfig "github.com/regclient/regclient/config"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultChunkSize  = 16 << 20
+	DefaultRetries    = 10
+	DefaultBackoff    = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// errSessionLost is returned when the registry no longer knows the upload session
+var errSessionLost = errors.New("upload session lost")
+
+// Options configures chunk size and retries of an upload
+type Options struct {
+	ChunkSize  int64
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	UserAgent  string
+	// Notify is called after every chunk and before every retry
+	Notify func(Status)
+}
+
+// Status reports the state of an upload
+type Status struct {
+	Digest   string
+	Uploaded int64
+	Size     int64
+	// Attempt, Wait and Err are set when a chunk failed and is about to be retried
+	Attempt int
+	Wait    time.Duration
+	Err     error
+	// Resumed is set when the registry reported how much of an interrupted upload it received
+	Resumed bool
+}
+
+// Uploader pushes blobs with chunked uploads that resume after connection failures
+type Uploader struct {
+	host   *regConfig.Host
+	client *http.Client
+	opts   Options
+}
+
+// statusError is an unexpected HTTP status from the registry
+type statusError struct {
+	method string
+	status int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("%s returned %d %s", e.method, e.status, http.StatusText(e.status))
+}
+
+// New creates an uploader for the registry host
+func New(host *regConfig.Host, opts Options) (*Uploader, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff < opts.Backoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if host.TLS == regConfig.TLSInsecure {
+		//#nosec G402 insecure TLS is explicitly configured for this registry
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if host.RegCert != "" || host.ClientCert != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if host.RegCert != "" {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM([]byte(host.RegCert)) {
+				return nil, fmt.Errorf("failed to load registry certificate of %s", host.Name)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if host.ClientCert != "" {
+			cert, err := tls.X509KeyPair([]byte(host.ClientCert), []byte(host.ClientKey))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate of %s: %w", host.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Uploader{
+		host:   host,
+		client: &http.Client{Transport: transport},
+		opts:   opts,
+	}, nil
+}
+
+// Upload pushes the blob described by desc to the repository of r, reading it from rdr
+func (u *Uploader) Upload(ctx context.Context, r ref.Ref, desc descriptor.Descriptor, rdr io.ReaderAt) (err error) {
+	a := &auth{host: u.host, scope: fmt.Sprintf("repository:%s:pull,push", u.repository(r))}
+	status := Status{Digest: desc.Digest.String(), Size: desc.Size}
+	location, err := u.retryStart(ctx, a, r, &status)
+	if err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", desc.Digest, err)
+	}
+
+	for status.Uploaded < desc.Size {
+		end := status.Uploaded + u.opts.ChunkSize
+		if end > desc.Size {
+			end = desc.Size
+		}
+
+		var next *url.URL
+		next, err = u.patch(ctx, a, location, io.NewSectionReader(rdr, status.Uploaded, end-status.Uploaded), status.Uploaded, end)
+		if err == nil {
+			location = next
+			status.Uploaded = end
+			status.Attempt, status.Wait, status.Err, status.Resumed = 0, 0, nil, false
+			u.notify(status)
+			continue
+		}
+
+		if ctx.Err() != nil || !retryable(err) {
+			return fmt.Errorf("failed to upload blob %s: %w", desc.Digest, err)
+		}
+
+		status.Attempt++
+		if status.Attempt > u.opts.Retries {
+			return fmt.Errorf("failed to upload blob %s after %d retries: %w", desc.Digest, u.opts.Retries, err)
+		}
+
+		status.Err = err
+		status.Wait = u.backoff(status.Attempt)
+		status.Resumed = false
+		u.notify(status)
+
+		err = sleep(ctx, status.Wait)
+		if err != nil {
+			return
+		}
+
+		var received int64
+		next, received, err = u.status(ctx, a, location, r, status.Uploaded)
+		switch {
+		case errors.Is(err, errSessionLost):
+			next, err = u.start(ctx, a, r)
+			if err != nil {
+				continue
+			}
+			location = next
+			status.Uploaded = 0
+		case err != nil:
+			continue
+		case received > desc.Size:
+			err = fmt.Errorf("registry reports %d bytes received of blob %s with %d bytes", received, desc.Digest, desc.Size)
+			return
+		default:
+			location = next
+			status.Uploaded = received
+		}
+
+		status.Err, status.Wait, status.Resumed = nil, 0, true
+		u.notify(status)
+	}
+
+	return u.finish(ctx, a, location, desc)
+}
+
+// retryStart opens an upload session like start, retrying failures the way the chunks are retried
+func (u *Uploader) retryStart(ctx context.Context, a *auth, r ref.Ref, status *Status) (*url.URL, error) {
+	for {
+		loc, err := u.start(ctx, a, r)
+		if err == nil || ctx.Err() != nil || !retryable(err) {
+			return loc, err
+		}
+
+		status.Attempt++
+		if status.Attempt > u.opts.Retries {
+			return nil, fmt.Errorf("failed to start upload after %d retries: %w", u.opts.Retries, err)
+		}
+
+		status.Err = err
+		status.Wait = u.backoff(status.Attempt)
+		u.notify(*status)
+
+		err = sleep(ctx, status.Wait)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// start opens an upload session and returns its location
+func (u *Uploader) start(ctx context.Context, a *auth, r ref.Ref) (*url.URL, error) {
+	startURL := &url.URL{
+		Scheme: "https",
+		Host:   u.host.Hostname,
+		Path:   fmt.Sprintf("/v2/%s/blobs/uploads/", u.repository(r)),
+	}
+	if u.host.TLS == regConfig.TLSDisabled {
+		startURL.Scheme = "http"
+	}
+
+	resp, err := u.do(ctx, a, http.MethodPost, startURL, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, statusError{method: http.MethodPost, status: resp.StatusCode}
+	}
+
+	return location(resp)
+}
+
+// repository is the path of the repository of r on the host, a mirror may serve it below the path prefix of the
+// host like regclient does
+func (u *Uploader) repository(r ref.Ref) string {
+	if u.host.PathPrefix != "" {
+		return u.host.PathPrefix + "/" + r.Repository
+	}
+
+	return r.Repository
+}
+
+// patch sends a chunk and returns the location of the next one
+func (u *Uploader) patch(ctx context.Context, a *auth, loc *url.URL, body io.ReadSeeker, start, end int64) (*url.URL, error) {
+	header := http.Header{
+		"Content-Type":  {"application/octet-stream"},
+		"Content-Range": {fmt.Sprintf("%d-%d", start, end-1)},
+	}
+
+	resp, err := u.do(ctx, a, http.MethodPatch, loc, body, end-start, header)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusCreated, http.StatusNoContent:
+		return location(resp)
+	case http.StatusNotFound:
+		return nil, errSessionLost
+	default:
+		return nil, statusError{method: http.MethodPatch, status: resp.StatusCode}
+	}
+}
+
+// status asks the registry how many bytes of the upload session it received, uploaded is how many were sent
+// successfully before
+func (u *Uploader) status(ctx context.Context, a *auth, loc *url.URL, r ref.Ref, uploaded int64) (next *url.URL, received int64, err error) {
+	resp, err := u.do(ctx, a, http.MethodGet, loc, nil, 0, nil)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusAccepted:
+	case http.StatusNotFound:
+		err = errSessionLost
+		return
+	default:
+		err = statusError{method: http.MethodGet, status: resp.StatusCode}
+		return
+	}
+
+	next, err = location(resp)
+	if err != nil {
+		return
+	}
+
+	// Range is inclusive, "0-99" means 100 bytes were received, registries answer "0-0" or no Range at all for an
+	// empty session
+	rangeHeader := resp.Header.Get("Range")
+	rangeStart, rangeEnd, found := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+	if rangeHeader == "" || (rangeStart == "0" && rangeEnd == "0") {
+		// a session that lost the chunks it accepted before cannot be resumed
+		if uploaded > 0 {
+			err = errSessionLost
+		}
+		return
+	}
+
+	if !found {
+		err = fmt.Errorf("invalid upload range %q from %s", rangeHeader, r.Registry)
+		return
+	}
+
+	end, err := strconv.ParseInt(rangeEnd, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("invalid upload range %q from %s: %w", rangeHeader, r.Registry, err)
+		return
+	}
+
+	received = end + 1
+	return
+}
+
+// finish closes the upload session, the registry verifies the digest
+func (u *Uploader) finish(ctx context.Context, a *auth, loc *url.URL, desc descriptor.Descriptor) error {
+	putURL := *loc
+	query := putURL.Query()
+	query.Set("digest", desc.Digest.String())
+	putURL.RawQuery = query.Encode()
+
+	resp, err := u.do(ctx, a, http.MethodPut, &putURL, nil, 0, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return fmt.Errorf("failed to finish upload of blob %s: %w", desc.Digest, err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to finish upload of blob %s: %w", desc.Digest, statusError{method: http.MethodPut, status: resp.StatusCode})
+	}
+
+	return nil
+}
+
+// do sends a request, answering a single authentication challenge
+func (u *Uploader) do(ctx context.Context, a *auth, method string, target *url.URL, body io.ReadSeeker, size int64, header http.Header) (resp *http.Response, err error) {
+	for authAttempt := 0; ; authAttempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			_, err = body.Seek(0, io.SeekStart)
+			if err != nil {
+				return
+			}
+			reqBody = body
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, target.String(), reqBody)
+		if err != nil {
+			return
+		}
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.ContentLength = size
+		if body == nil {
+			req.Body = http.NoBody
+		}
+		if u.opts.UserAgent != "" {
+			req.Header.Set("User-Agent", u.opts.UserAgent)
+		}
+		if a.header != "" {
+			req.Header.Set("Authorization", a.header)
+		}
+
+		resp, err = u.client.Do(req)
+		if err != nil {
+			return
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || authAttempt > 0 {
+			return
+		}
+
+		wwwAuth := resp.Header.Get("WWW-Authenticate")
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		err = a.challenge(ctx, u.client, u.opts.UserAgent, wwwAuth)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (u *Uploader) backoff(attempt int) time.Duration {
+	wait := u.opts.Backoff
+	for i := 1; i < attempt && wait < u.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > u.opts.MaxBackoff {
+		wait = u.opts.MaxBackoff
+	}
+
+	return wait
+}
+
+func (u *Uploader) notify(status Status) {
+	if u.opts.Notify != nil {
+		u.opts.Notify(status)
+	}
+}
+
+// location resolves the Location header of an upload response
+func location(resp *http.Response) (*url.URL, error) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, fmt.Errorf("%s %s returned no upload location", resp.Request.Method, resp.Request.URL.Redacted())
+	}
+
+	return resp.Request.URL.Parse(loc)
+}
+
+// retryable reports whether an upload error is worth resuming
+func retryable(err error) bool {
+	if errors.Is(err, errSessionLost) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	// an unknown host or a certificate that is not trusted does not go away by retrying
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	if tlsError(err) {
+		return false
+	}
+
+	// connection failures are retried, a dropped mobile link shows up as an unreachable network or a failed dial
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ECONNREFUSED, syscall.EPIPE,
+		syscall.ENETUNREACH, syscall.ENETDOWN, syscall.EHOSTUNREACH, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	var se statusError
+	if errors.As(err, &se) {
+		return se.status >= 500 || se.status == http.StatusRequestTimeout || se.status == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// tlsError reports whether err is a failed TLS handshake or certificate verification
+func tlsError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+		rootsErr     x509.SystemRootsError
+	)
+	return errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &hostnameErr) || errors.As(err, &rootsErr)
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
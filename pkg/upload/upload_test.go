@@ -0,0 +1,241 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	regConfig "github.com/regclient/regclient/config"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/ref"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeRegistry implements the blob upload API, failing the configured PATCH requests halfway
+type fakeRegistry struct {
+	mu        sync.Mutex
+	received  []byte
+	blob      []byte
+	patches   int
+	failPatch map[int]bool
+	failPost  int
+	noRange   bool
+	zeroRange bool
+	token     string
+	prefix    string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	repository := "models/demo"
+	if f.prefix != "" {
+		repository = f.prefix + "/" + repository
+	}
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("scope") != "repository:"+repository+":pull,push" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"token":%q}`, f.token)
+		return
+	}
+	if f.token != "" && req.Header.Get("Authorization") != "Bearer "+f.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	uploadPath := "/v2/" + repository + "/blobs/uploads/session"
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path != "/v2/"+repository+"/blobs/uploads/":
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == http.MethodPost && f.failPost > 0:
+		f.failPost--
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case req.Method == http.MethodPost:
+		f.received = nil
+		w.Header().Set("Location", uploadPath)
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPatch:
+		f.patches++
+		body, _ := io.ReadAll(req.Body)
+		var start int
+		fmt.Sscanf(req.Header.Get("Content-Range"), "%d-", &start)
+		if start != len(f.received) {
+			w.Header().Set("Location", uploadPath)
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(f.received)-1))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if f.failPatch[f.patches] {
+			f.received = append(f.received, body[:len(body)/2]...)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		f.received = append(f.received, body...)
+		w.Header().Set("Location", uploadPath)
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodGet:
+		w.Header().Set("Location", uploadPath)
+		switch {
+		case f.zeroRange:
+			w.Header().Set("Range", "0-0")
+		case !f.noRange:
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(f.received)-1))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPut:
+		if digest.FromBytes(f.received).String() != req.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blob = f.received
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestUploadResume(t *testing.T) {
+	t.Parallel()
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	desc := descriptor.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+
+	tests := []struct {
+		name      string
+		failPatch map[int]bool
+		failPost  int
+		noRange   bool
+		zeroRange bool
+		token     string
+		prefix    string
+		retries   int
+		expectErr bool
+	}{
+		{name: "no failure"},
+		{name: "resume", failPatch: map[int]bool{2: true, 4: true}, retries: 3},
+		{name: "bearer auth", failPatch: map[int]bool{3: true}, token: "secret", retries: 1},
+		{name: "retries exhausted", failPatch: map[int]bool{2: true, 3: true}, retries: 1, expectErr: true},
+		{name: "start retried", failPost: 2, retries: 2},
+		{name: "start retries exhausted", failPost: 2, retries: 1, expectErr: true},
+		{name: "status without range restarts", failPatch: map[int]bool{3: true}, noRange: true, retries: 1},
+		{name: "status with empty range restarts", failPatch: map[int]bool{3: true}, zeroRange: true, retries: 1},
+		{name: "path prefix", failPatch: map[int]bool{2: true}, token: "secret", prefix: "mirror", retries: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			reg := &fakeRegistry{failPatch: tt.failPatch, failPost: tt.failPost, noRange: tt.noRange, zeroRange: tt.zeroRange,
+				token: tt.token, prefix: tt.prefix}
+			ts := httptest.NewServer(reg)
+			defer ts.Close()
+
+			host := regConfig.HostNewName(strings.TrimPrefix(ts.URL, "http://"))
+			host.TLS = regConfig.TLSDisabled
+			host.PathPrefix = tt.prefix
+			resumed := 0
+			uploader, err := New(host, Options{
+				ChunkSize: 300,
+				Retries:   tt.retries,
+				Backoff:   time.Millisecond,
+				Notify: func(status Status) {
+					if status.Resumed {
+						resumed++
+					}
+				},
+			})
+			if err != nil {
+				t.Fatalf("failed to create uploader: %v", err)
+			}
+
+			r, err := ref.New(host.Name + "/models/demo:v1")
+			if err != nil {
+				t.Fatalf("failed to parse ref: %v", err)
+			}
+
+			err = uploader.Upload(context.Background(), r, desc, bytes.NewReader(content))
+			if tt.expectErr {
+				if err == nil {
+					t.Fatalf("upload did not fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to upload: %v", err)
+			}
+			if !bytes.Equal(reg.blob, content) {
+				t.Errorf("uploaded blob differs")
+			}
+			if resumed != len(tt.failPatch) {
+				t.Errorf("expected %d resumes, received %d", len(tt.failPatch), resumed)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{name: "session lost", err: errSessionLost, expect: true},
+		{name: "connection reset", err: &url.Error{Op: "Patch", URL: "http://reg", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, expect: true},
+		{name: "timeout", err: &url.Error{Op: "Patch", URL: "http://reg", Err: context.DeadlineExceeded}, expect: true},
+		{name: "closed connection", err: &url.Error{Op: "Patch", URL: "http://reg", Err: io.EOF}, expect: true},
+		{name: "connection refused", err: &url.Error{Op: "Post", URL: "http://reg", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, expect: true},
+		{name: "network unreachable", err: &url.Error{Op: "Patch", URL: "http://reg", Err: &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ENETUNREACH)}}, expect: true},
+		{name: "host unreachable", err: &url.Error{Op: "Put", URL: "http://reg", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.EHOSTUNREACH)}}, expect: true},
+		{name: "dial", err: &url.Error{Op: "Post", URL: "http://reg", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route")}}, expect: true},
+		{name: "temporary dns failure", err: &url.Error{Op: "Post", URL: "http://reg", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "server misbehaving", Name: "reg", IsTemporary: true}}}, expect: true},
+		{name: "unknown host", err: &url.Error{Op: "Post", URL: "http://reg", Err: &net.DNSError{Err: "no such host", Name: "reg", IsNotFound: true}}},
+		{name: "untrusted certificate", err: &url.Error{Op: "Post", URL: "https://reg", Err: x509.UnknownAuthorityError{}}},
+		{name: "unknown host on dial", err: &url.Error{Op: "Post", URL: "http://reg", Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "reg", IsNotFound: true}}}},
+		{name: "certificate hostname", err: &url.Error{Op: "Post", URL: "https://reg", Err: &tls.CertificateVerificationError{Err: x509.HostnameError{Host: "reg"}}}},
+		{name: "tls to http server", err: &url.Error{Op: "Post", URL: "https://reg", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}},
+		{name: "server error", err: statusError{method: http.MethodPatch, status: http.StatusBadGateway}, expect: true},
+		{name: "range not satisfiable", err: statusError{method: http.MethodPatch, status: http.StatusRequestedRangeNotSatisfiable}},
+	}
+
+	for _, tt := range tests {
+		if received := retryable(tt.err); received != tt.expect {
+			t.Errorf("%s: expected retryable %t, received %t", tt.name, tt.expect, received)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	t.Parallel()
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.org/token",service="registry.example.org",scope="repository:a/b:pull,push"`)
+	if scheme != "Bearer" {
+		t.Errorf("unexpected scheme %s", scheme)
+	}
+	expect := map[string]string{
+		"realm":   "https://auth.example.org/token",
+		"service": "registry.example.org",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for key, value := range expect {
+		if params[key] != value {
+			t.Errorf("unexpected %s: expected %s, received %s", key, value, params[key])
+		}
+	}
+}
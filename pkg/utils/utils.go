@@ -1,28 +1,42 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
 	ct "github.com/daviddengcn/go-colortext"
 	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// colorMu guards the writer go-colortext writes its escape codes to
+var colorMu sync.Mutex
+
 func PrintYellow(out io.Writer, content string) {
-	ct.ChangeColor(ct.Yellow, false, ct.None, false)
-	_, _ = fmt.Fprint(out, content)
-	ct.ResetColor()
+	printColor(out, ct.Yellow, content)
 }
 
 func PrintWarning(out io.Writer, name string) {
-	ct.ChangeColor(ct.Red, false, ct.None, false)
-	_, _ = fmt.Fprint(out, name)
-	ct.ResetColor()
+	printColor(out, ct.Red, name)
 }
 
 func PrintString(out io.Writer, name string) {
-	ct.ChangeColor(ct.Green, false, ct.None, false)
-	_, _ = fmt.Fprint(out, name)
+	printColor(out, ct.Green, name)
+}
+
+// printColor writes content in color, the escape codes go to out as well so a report on stdout stays parsable
+func printColor(out io.Writer, color ct.Color, content string) {
+	colorMu.Lock()
+	defer colorMu.Unlock()
+
+	ct.Writer = out
+	ct.ChangeColor(color, false, ct.None, false)
+	_, _ = fmt.Fprint(out, content)
 	ct.ResetColor()
+	ct.Writer = os.Stdout
 }
 
 func IsDir(path string) bool {
@@ -33,6 +47,39 @@ func IsDir(path string) bool {
 	return info.IsDir()
 }
 
+// WriteFileIfChanged only writes content when the file differs, keeping the modification time of unchanged files
+func WriteFileIfChanged(name string, content []byte, perm os.FileMode) error {
+	current, err := os.ReadFile(name)
+	if err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+
+	return os.WriteFile(name, content, perm)
+}
+
+// ParseSize parses sizes like 512, 64K, 16MiB or 2GB as bytes, units are powers of 1024
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	unit := strings.TrimLeft(value, "0123456789")
+	number, err := strconv.ParseInt(strings.TrimSuffix(value, unit), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	multipliers := map[string]int64{"": 1, "b": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}
+	unit = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(unit), "b"), "i")
+	multiplier, ok := multipliers[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+
+	if number > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+
+	return number * multiplier, nil
+}
+
 func SplitCSKV(s string) (map[string]string, error) {
 	state := "key"
 	key := ""
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "bytes", size: "512", want: 512},
+		{name: "byte unit", size: "512b", want: 512},
+		{name: "kilobytes", size: "64K", want: 64 << 10},
+		{name: "mebibytes", size: "16MiB", want: 16 << 20},
+		{name: "gigabytes", size: " 2GB ", want: 2 << 30},
+		{name: "terabytes", size: "1t", want: 1 << 40},
+		{name: "max bytes", size: "9223372036854775807", want: math.MaxInt64},
+		{name: "max terabytes", size: "8388607T", want: 8388607 << 40},
+		{name: "empty", size: "", wantErr: true},
+		{name: "no number", size: "MiB", wantErr: true},
+		{name: "negative", size: "-1K", wantErr: true},
+		{name: "unknown unit", size: "5X", wantErr: true},
+		{name: "number overflow", size: "9223372036854775808", wantErr: true},
+		{name: "unit overflow", size: "8388608T", wantErr: true},
+		{name: "large unit overflow", size: "9223372036854775807K", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}